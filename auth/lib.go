@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -111,24 +112,24 @@ func NewVerifier(hexPubKey string, targMachId string, liveness time.Duration) (V
 
 		msg, ok := sign.Open(nil, sig, pubKey)
 		if !ok {
-			log.Printf("bad signature for %s", auth)
+			slog.Warn("auth: bad signature")
 			return ErrBadAuth
 		}
 
 		ts, machId, err := parseMsg(string(msg))
 		if err != nil {
-			log.Printf("bad message format: %v", err)
+			slog.Warn("auth: bad message format", "err", err)
 			return ErrBadAuth
 		}
 
 		dt := now.Sub(ts)
 		if !(-timeSlack < dt && dt < liveness) {
-			log.Printf("bad ts %v (dt=%v)", ts, dt)
+			slog.Warn("auth: bad timestamp", "ts", ts, "dt", dt)
 			return ErrBadAuth
 		}
 
 		if machId != targMachId {
-			log.Printf("bad machId %v != %v", machId, targMachId)
+			slog.Warn("auth: bad machine ID", "got", machId, "want", targMachId)
 			return ErrBadAuth
 		}
 
@@ -53,7 +53,7 @@ func TestAuth(t *testing.T) {
 	assert.Error(t, err)
 
 	// verify fails with large clock skew.
-	err = verifier1234(now.Add(-3*time.Second), auth)
+	err = verifier1234(now.Add(-6*time.Second), auth)
 	assert.Error(t, err)
 
 	// verify fails if the machine id does not match
@@ -6,19 +6,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/superfly/coordBfaas/logging"
+	"github.com/superfly/coordBfaas/tracing"
 )
 
+// ExitCodeTrailer is the trailer in which a run reports the script's exit code.
+const ExitCodeTrailer = "Bfaas-Exit-Code"
+
 type Handler func(w http.ResponseWriter, r *http.Request)
 
 func (s *Server) withOnce(next Handler) Handler {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if s.used.Swap(true) {
-			log.Printf("basher: received second request")
+			slog.WarnContext(r.Context(), "basher: received second request")
 			http.Error(w, "conflict", http.StatusConflict)
 			return
 		}
@@ -30,9 +40,35 @@ func (s *Server) withOnce(next Handler) Handler {
 	}
 }
 
+// handleHealth reports that the server is up.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Worker", os.Getenv("FLY_MACHINE_ID"))
+	fmt.Fprintf(w, "ok\n")
+}
+
+// handleReady reports whether the server can still accept its one run request.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Worker", os.Getenv("FLY_MACHINE_ID"))
+	if s.used.Load() {
+		http.Error(w, "used", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ready\n")
+}
+
 func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if id := r.Header.Get(logging.RequestIdHeader); id != "" {
+		ctx = logging.WithRequestId(ctx, id)
+		w.Header().Set(logging.RequestIdHeader, id)
+	}
+	ctx, span := tracing.Start(tracing.Extract(ctx, r.Header), "basher", "basher.run",
+		trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	raw := r.URL.Query().Get("raw") != ""
 	w.Header().Set("Worker", os.Getenv("FLY_MACHINE_ID"))
+	w.Header().Set("Trailer", ExitCodeTrailer)
 	if !raw {
 		w.Header().Set("Content-Type", "text/event-stream")
 	}
@@ -45,8 +81,11 @@ func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	log.Printf("basher: running %q", string(bs))
-	cmd := exec.CommandContext(r.Context(), "/bin/bash", "-c", string(bs))
+	slog.InfoContext(ctx, "basher: running", "script_bytes", len(bs))
+	if logging.LogBodies() {
+		slog.DebugContext(ctx, "basher: script", "script", string(bs))
+	}
+	cmd := exec.CommandContext(ctx, "/bin/bash", "-c", string(bs))
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -73,7 +112,9 @@ func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 			}
 			s := string(buf[:n])
 
-			log.Printf("basher: delivering %s %q", event, s)
+			if logging.LogBodies() {
+				slog.DebugContext(ctx, "basher: delivering", "event", event, "chunk", s)
+			}
 			mu.Lock()
 			if raw {
 				fmt.Fprintf(w, "%s", s)
@@ -94,9 +135,24 @@ func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 	go copier("stdout", stdout)
 	go copier("stderr", stderr)
 
+	// All output must be read before waiting for the command,
+	// since waiting closes the pipes.
+	_, startSpan := tracing.Start(ctx, "basher", "basher.start")
+	err = cmd.Start()
+	tracing.End(startSpan, err)
+	if err == nil {
+		_, exitSpan := tracing.Start(ctx, "basher", "basher.exit")
+		wg.Wait()
+		err = cmd.Wait()
+		exitSpan.SetAttributes(attribute.Int("process.exit.code", cmd.ProcessState.ExitCode()))
+		exitSpan.End()
+	} else {
+		wg.Wait()
+	}
+
 	var exitCode int
-	if err := cmd.Run(); err != nil {
-		log.Printf("basher: command exit %v", err)
+	if err != nil {
+		slog.InfoContext(ctx, "basher: command exit", "err", err)
 
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
@@ -106,11 +162,11 @@ func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	wg.Wait()
-	log.Printf("basher: done with code %d", exitCode)
+	slog.InfoContext(ctx, "basher: done", "code", exitCode)
 	if raw {
 		fmt.Fprintf(w, "\nexit: %d\n", exitCode)
 	} else {
 		fmt.Fprintf(w, "event: exit\ndata: {\"code\":%d}\n\n", exitCode)
 	}
+	w.Header().Set(ExitCodeTrailer, strconv.Itoa(exitCode))
 }
@@ -15,6 +15,8 @@ func New(port int, machId string) (*Server, error) {
 	server := &Server{}
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /run", server.withOnce(server.handleRun))
+	mux.HandleFunc("GET /healthz", server.handleHealth)
+	mux.HandleFunc("GET /readyz", server.handleReady)
 
 	server.Server = &http.Server{
 		// No timeouts set.
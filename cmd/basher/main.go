@@ -1,19 +1,32 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"time"
 
 	"github.com/superfly/coordBfaas/basher"
+	"github.com/superfly/coordBfaas/logging"
+	"github.com/superfly/coordBfaas/tracing"
 )
 
 func main() {
+	if err := logging.Setup(os.Getenv("LOG_LEVEL"), os.Getenv("LOG_BODIES") != ""); err != nil {
+		log.Fatalf("LOG_LEVEL: %v", err)
+	}
+
 	machId := os.Getenv("FLY_MACHINE_ID")
 	if machId == "" {
 		log.Fatalf("need FLY_MACHINE_ID")
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), "bfaas-basher", os.Getenv("TRACING"))
+	if err != nil {
+		log.Fatalf("tracing.Setup: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	srv, err := basher.New(8001, machId)
 	if err != nil {
 		log.Fatalf("basher.New: %v", err)
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+
+	"github.com/superfly/coordBfaas/auth"
+	"github.com/superfly/coordBfaas/coord"
+	"github.com/superfly/coordBfaas/machines"
+	"github.com/superfly/coordBfaas/machines/pool"
+)
+
+// config is coord's configuration. It is read from the environment,
+// and then from the YAML file in CONFIG_FILE, if set, whose values win.
+// FLY_TOKEN, FLY_MACHINE_ID and POOL_SECRET are only read from the environment.
+type config struct {
+	Port        int           `yaml:"port"`
+	AdminPort   int           `yaml:"admin_port"`
+	MaxReqTime  time.Duration `yaml:"max_req_time"`
+	MaxBodySize int64         `yaml:"max_body_size"`
+	FlyReplay   bool          `yaml:"fly_replay"`
+
+	InboundHeaders  []string `yaml:"inbound_headers"`
+	OutboundHeaders []string `yaml:"outbound_headers"`
+
+	LogLevel  string `yaml:"log_level"`
+	LogBodies bool   `yaml:"log_bodies"`
+	Tracing   string `yaml:"tracing"`
+
+	AdminPubkey string      `yaml:"admin_pubkey"`
+	Audit       auditConfig `yaml:"audit"`
+
+	Pool    poolConfig    `yaml:"pool"`
+	Classes []classConfig `yaml:"classes"`
+}
+
+type auditConfig struct {
+	File    string `yaml:"file"`
+	Webhook string `yaml:"webhook"`
+	Scripts bool   `yaml:"scripts"`
+}
+
+type poolConfig struct {
+	// App is the worker app, or "mock" for a mock pool.
+	App        string         `yaml:"app"`
+	Image      string         `yaml:"image"`
+	Region     string         `yaml:"region"`
+	Regions    []regionConfig `yaml:"regions"`
+	Size       int            `yaml:"size"`
+	Guest      *guestConfig   `yaml:"guest"`
+	Autoscale  *scaleConfig   `yaml:"autoscale"`
+	WorkerPort int            `yaml:"worker_port"`
+
+	// Env is set in the environment of all workers.
+	Env map[string]string `yaml:"env"`
+
+	// WorkerTime defaults to twice max_req_time.
+	WorkerTime time.Duration `yaml:"worker_time"`
+	LeaseTime  time.Duration `yaml:"lease_time"`
+	ReadyTime  time.Duration `yaml:"ready_time"`
+
+	// RenewBefore is when free machines' leases are renewed, defaulting to
+	// half of lease_time. Negative disables renewal.
+	RenewBefore time.Duration `yaml:"renew_before"`
+	RenewRate   float64       `yaml:"renew_rate"`
+
+	// ReconcileTimeout is how long coord waits at startup for the pools to adopt
+	// machines left by its last instance, before serving anyway. Zero skips the wait.
+	ReconcileTimeout time.Duration `yaml:"reconcile_timeout"`
+
+	// StateDir, if set, holds the pools' state files, so a restarted coord can use its machines right away.
+	StateDir string `yaml:"state_dir"`
+}
+
+type regionConfig struct {
+	Region   string `yaml:"region"`
+	Capacity int    `yaml:"capacity"`
+}
+
+type guestConfig struct {
+	CpuKind  string `yaml:"cpu_kind"`
+	Cpus     int    `yaml:"cpus"`
+	MemoryMb int    `yaml:"memory_mb"`
+}
+
+type scaleConfig struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// classConfig is a worker class. Its pool is in the pool's region,
+// and uses the pool's image unless it has its own.
+type classConfig struct {
+	Name       string        `yaml:"name"`
+	Capacity   int           `yaml:"capacity"`
+	MaxReqTime time.Duration `yaml:"max_req_time"`
+	Guest      *guestConfig  `yaml:"guest"`
+	Image      string        `yaml:"image"`
+}
+
+// workerTime is how long a class's workers are allocated for.
+func (cl classConfig) workerTime() time.Duration {
+	return 2 * cl.MaxReqTime
+}
+
+func defaultConfig() *config {
+	return &config{
+		Port:        8000,
+		AdminPort:   9091,
+		MaxBodySize: 10 * 1024 * 1024,
+		Pool: poolConfig{
+			Region:     "qmx",
+			WorkerPort: 8001,
+			LeaseTime:  5 * time.Minute,
+			ReadyTime:  10 * time.Second,
+			RenewRate:  float64(pool.DefaultRenewRate),
+
+			ReconcileTimeout: 30 * time.Second,
+		},
+	}
+}
+
+// loadConfig reads the configuration from the environment and then from path, if not empty.
+func loadConfig(path string) (*config, error) {
+	c, err := configFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(bs))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	if c.Pool.WorkerTime == 0 {
+		c.Pool.WorkerTime = 2 * c.MaxReqTime
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configFromEnv reads the configuration from the environment.
+func configFromEnv() (*config, error) {
+	c := defaultConfig()
+	var errs []error
+	env := func(name string, parse func(string) error) {
+		if v := os.Getenv(name); v != "" {
+			if err := parse(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	str := func(p *string) func(string) error {
+		return func(v string) error { *p = v; return nil }
+	}
+	list := func(p *[]string) func(string) error {
+		return func(v string) error { *p = strings.Split(v, ","); return nil }
+	}
+
+	env("WORKER_APP", str(&c.Pool.App))
+	env("WORKER_IMAGE", str(&c.Pool.Image))
+	env("FLY_REGION", str(&c.Pool.Region))
+	env("MAXREQTIME", func(v string) (err error) { c.MaxReqTime, err = time.ParseDuration(v); return })
+	env("POOLSIZE", func(v string) (err error) { c.Pool.Size, err = strconv.Atoi(v); return })
+	env("MAXBODYSIZE", func(v string) (err error) { c.MaxBodySize, err = strconv.ParseInt(v, 10, 64); return })
+	env("ADMIN_PORT", func(v string) (err error) { c.AdminPort, err = strconv.Atoi(v); return })
+	env("INBOUND_HEADERS", list(&c.InboundHeaders))
+	env("OUTBOUND_HEADERS", list(&c.OutboundHeaders))
+	env("LOG_LEVEL", str(&c.LogLevel))
+	env("TRACING", str(&c.Tracing))
+	env("ADMIN_PUBKEY", str(&c.AdminPubkey))
+	env("AUDIT_FILE", str(&c.Audit.File))
+	env("AUDIT_WEBHOOK", str(&c.Audit.Webhook))
+	env("STATE_DIR", str(&c.Pool.StateDir))
+	env("LEASE_TIME", func(v string) (err error) { c.Pool.LeaseTime, err = time.ParseDuration(v); return })
+	env("RECONCILE_TIMEOUT", func(v string) (err error) { c.Pool.ReconcileTimeout, err = time.ParseDuration(v); return })
+	c.FlyReplay = os.Getenv("FLY_REPLAY") != ""
+	c.LogBodies = os.Getenv("LOG_BODIES") != ""
+	c.Audit.Scripts = os.Getenv("AUDIT_SCRIPTS") != ""
+
+	env("AUTOSCALE", func(v string) error {
+		c.Pool.Autoscale = &scaleConfig{}
+		_, err := fmt.Sscanf(v, "%d:%d", &c.Pool.Autoscale.Min, &c.Pool.Autoscale.Max)
+		return err
+	})
+	env("REGIONS", func(v string) error {
+		regions, err := pool.ParseRegions(v)
+		for _, r := range regions {
+			c.Pool.Regions = append(c.Pool.Regions, regionConfig{r.Region, r.Capacity})
+		}
+		return err
+	})
+	env("WORKER_CLASSES", func(v string) (err error) { c.Classes, err = parseClasses(v); return })
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseClasses parses a comma separated list of worker classes, each
+// name:capacity:maxreqtime:cpu_kind:cpus:memory_mb[:image], such as
+// "small:4:30s:shared:1:256,large-cpu:1:5m:performance:4:8192".
+// The image may contain colons.
+func parseClasses(s string) ([]classConfig, error) {
+	var classes []classConfig
+	for _, w := range strings.Split(s, ",") {
+		fs := strings.SplitN(strings.TrimSpace(w), ":", 7)
+		if len(fs) < 6 {
+			return nil, fmt.Errorf("bad class %q, want name:capacity:maxreqtime:cpu_kind:cpus:memory_mb[:image]", w)
+		}
+
+		c := classConfig{Name: fs[0], Guest: &guestConfig{CpuKind: fs[3]}}
+		var err error
+		if c.Capacity, err = strconv.Atoi(fs[1]); err != nil {
+			return nil, fmt.Errorf("class %s: bad capacity %q", c.Name, fs[1])
+		}
+		if c.MaxReqTime, err = time.ParseDuration(fs[2]); err != nil {
+			return nil, fmt.Errorf("class %s: bad maxreqtime %q", c.Name, fs[2])
+		}
+		if c.Guest.Cpus, err = strconv.Atoi(fs[4]); err != nil {
+			return nil, fmt.Errorf("class %s: bad cpus %q", c.Name, fs[4])
+		}
+		if c.Guest.MemoryMb, err = strconv.Atoi(fs[5]); err != nil {
+			return nil, fmt.Errorf("class %s: bad memory_mb %q", c.Name, fs[5])
+		}
+		if len(fs) == 7 {
+			c.Image = fs[6]
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// validate checks the configuration, returning every problem it finds.
+func (c *config) validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+	checkPort := func(port int, field string) {
+		check(port >= 1 && port <= 65535, field, "bad port %d", port)
+	}
+
+	checkPort(c.Port, "port")
+	// An admin port of 0 disables the admin server.
+	if c.AdminPort != 0 {
+		checkPort(c.AdminPort, "admin_port")
+		check(c.Port != c.AdminPort, "admin_port", "must differ from port")
+	}
+	check(c.MaxReqTime > 0, "max_req_time", "must be set and positive")
+	check(c.MaxBodySize > 0, "max_body_size", "must be positive")
+	var level slog.Level
+	check(c.LogLevel == "" || level.UnmarshalText([]byte(c.LogLevel)) == nil, "log_level",
+		"want debug, info, warn or error, got %q", c.LogLevel)
+	check(c.Tracing == "" || c.Tracing == "otlp" || c.Tracing == "stdout", "tracing", "want otlp or stdout, got %q", c.Tracing)
+	if c.AdminPubkey != "" {
+		_, err := auth.NewVerifier(c.AdminPubkey, "", time.Minute)
+		check(err == nil, "admin_pubkey", "%v", err)
+	}
+
+	p := &c.Pool
+	check(p.App != "", "pool.app", "must be set")
+	mock := p.App == "mock"
+	check(mock || p.Image != "", "pool.image", "must be set")
+	if len(p.Regions) == 0 {
+		check(p.Size >= 1, "pool.size", "must be at least 1")
+		check(mock || p.Region != "", "pool.region", "must be set")
+	}
+	regions := map[string]bool{}
+	for i, r := range p.Regions {
+		field := fmt.Sprintf("pool.regions[%d]", i)
+		check(r.Region != "", field+".region", "must be set")
+		check(!regions[r.Region], field+".region", "%q listed twice", r.Region)
+		check(r.Capacity >= 1, field+".capacity", "must be at least 1")
+		regions[r.Region] = true
+	}
+	c.checkGuest(p.Guest, "pool.guest", check)
+	if p.Autoscale != nil {
+		check(p.Autoscale.Min >= 1, "pool.autoscale.min", "must be at least 1")
+		check(p.Autoscale.Max >= p.Autoscale.Min, "pool.autoscale.max", "must be at least min")
+	}
+	checkPort(p.WorkerPort, "pool.worker_port")
+	check(p.WorkerTime >= c.MaxReqTime, "pool.worker_time", "must be at least max_req_time")
+	check(p.LeaseTime > p.WorkerTime, "pool.lease_time", "must be more than worker_time (%v)", p.WorkerTime)
+	check(p.ReadyTime > 0, "pool.ready_time", "must be positive")
+	check(p.RenewBefore < p.LeaseTime, "pool.renew_before", "must be less than lease_time")
+	check(p.RenewRate > 0, "pool.renew_rate", "must be positive")
+	check(p.ReconcileTimeout >= 0, "pool.reconcile_timeout", "must not be negative")
+
+	classes := map[string]bool{}
+	for i, cl := range c.Classes {
+		field := fmt.Sprintf("classes[%d]", i)
+		check(cl.Name != "", field+".name", "must be set")
+		check(cl.Name != coord.DefaultClass, field+".name", "%q is the pool's class", coord.DefaultClass)
+		check(!classes[cl.Name], field+".name", "%q defined twice", cl.Name)
+		check(cl.Capacity >= 1, field+".capacity", "must be at least 1")
+		check(cl.MaxReqTime > 0, field+".max_req_time", "must be set and positive")
+		check(p.LeaseTime > cl.workerTime(), field+".max_req_time", "worker time %v must be less than pool.lease_time", cl.workerTime())
+		c.checkGuest(cl.Guest, field+".guest", check)
+		classes[cl.Name] = true
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("bad config:\n%w", err)
+	}
+	return nil
+}
+
+func (c *config) checkGuest(g *guestConfig, field string, check func(bool, string, string, ...any)) {
+	if g == nil {
+		return
+	}
+	check(g.CpuKind == "shared" || g.CpuKind == "performance", field+".cpu_kind", "want shared or performance, got %q", g.CpuKind)
+	check(g.Cpus >= 1, field+".cpus", "must be at least 1")
+	check(g.MemoryMb >= 256, field+".memory_mb", "must be at least 256")
+}
+
+// opts returns the pool option for the guest, if set.
+func (g *guestConfig) opts() []pool.Opt {
+	if g == nil {
+		return nil
+	}
+	return []pool.Opt{pool.Guest(&machines.Guest{CpuKind: g.CpuKind, Cpus: g.Cpus, MemoryMb: g.MemoryMb})}
+}
+
+// poolOpts returns the options shared by all of coord's pools.
+func (c *config) poolOpts() []pool.Opt {
+	p := &c.Pool
+	opts := []pool.Opt{
+		pool.Port(p.WorkerPort), pool.WorkerTime(p.WorkerTime),
+		pool.LeaseTime(p.LeaseTime), pool.ReadyTime(p.ReadyTime),
+		pool.RenewLeases(p.RenewBefore, rate.Limit(p.RenewRate)),
+	}
+	if p.StateDir != "" {
+		opts = append(opts, pool.StateDir(p.StateDir))
+	}
+	if len(p.Env) > 0 {
+		opts = append(opts, pool.Env(p.Env))
+	}
+	return opts
+}
+
+// regions returns the pool's regions, if any.
+func (c *config) regions() []pool.RegionSize {
+	var regions []pool.RegionSize
+	for _, r := range c.Pool.Regions {
+		regions = append(regions, pool.RegionSize{Region: r.Region, Capacity: r.Capacity})
+	}
+	return regions
+}
+
+// drainTime is the longest any run may take.
+func (c *config) drainTime() time.Duration {
+	d := c.MaxReqTime
+	for _, cl := range c.Classes {
+		d = max(d, cl.MaxReqTime)
+	}
+	return d
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func writeConfig(t *testing.T, s string) string {
+	path := filepath.Join(t.TempDir(), "coord.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(s), 0600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("WORKER_APP", "bfaas-worker")
+	t.Setenv("MAXREQTIME", "10s")
+	t.Setenv("POOLSIZE", "3")
+	t.Setenv("LEASE_TIME", "15m")
+
+	path := writeConfig(t, `
+max_req_time: 20s
+pool:
+  image: registry.fly.io/worker:latest
+  guest: {cpu_kind: shared, cpus: 1, memory_mb: 512}
+  env: {LOG_LEVEL: debug}
+classes:
+  - {name: large-cpu, capacity: 1, max_req_time: 5m, guest: {cpu_kind: performance, cpus: 4, memory_mb: 8192}}
+`)
+	c, err := loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "bfaas-worker", c.Pool.App)
+	assert.Equal(t, 3, c.Pool.Size)
+	assert.Equal(t, 20*time.Second, c.MaxReqTime)
+	assert.Equal(t, 40*time.Second, c.Pool.WorkerTime)
+	assert.Equal(t, 15*time.Minute, c.Pool.LeaseTime)
+	assert.Equal(t, 8001, c.Pool.WorkerPort)
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "debug"}, c.Pool.Env)
+	assert.Equal(t, 5*time.Minute, c.drainTime())
+	assert.Equal(t, 8192, c.Classes[0].Guest.MemoryMb)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := writeConfig(t, `max_reqtime: 10s`)
+	_, err := loadConfig(path)
+	assert.EqualError(t, err, "config "+path+": yaml: unmarshal errors:\n  line 1: field max_reqtime not found in type main.config")
+
+	path = writeConfig(t, `
+max_req_time: 10s
+pool: {app: w, image: i, size: 0, guest: {cpu_kind: fast, cpus: 1, memory_mb: 512}}
+classes: [{name: default, capacity: 1, max_req_time: 1s}, {name: big, capacity: 1, max_req_time: 3m}]
+`)
+	_, err = loadConfig(path)
+	assert.EqualError(t, err, `bad config:
+pool.size: must be at least 1
+pool.guest.cpu_kind: want shared or performance, got "fast"
+classes[0].name: "default" is the pool's class
+classes[1].max_req_time: worker time 6m0s must be less than pool.lease_time`)
+}
+
+func TestWithRuntime(t *testing.T) {
+	old := defaultConfig()
+	old.Pool.Size = 2
+	old.Classes = []classConfig{{Name: "big", Capacity: 1, MaxReqTime: time.Minute}}
+
+	new := defaultConfig()
+	new.Pool.Size = 4
+	new.Port = 8080
+	new.Classes = []classConfig{{Name: "big", Capacity: 3, MaxReqTime: 2 * time.Minute}}
+
+	next := old.withRuntime(new)
+	assert.Equal(t, 4, next.Pool.Size)
+	assert.Equal(t, 8000, next.Port)
+	assert.Equal(t, []classConfig{{Name: "big", Capacity: 3, MaxReqTime: time.Minute}}, next.Classes)
+	assert.Equal(t, 1, old.Classes[0].Capacity)
+	assert.Equal(t, []string{"port", "classes"}, diffFields(reflect.ValueOf(*next), reflect.ValueOf(*new), ""))
+}
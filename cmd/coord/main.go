@@ -1,83 +1,199 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
+	"github.com/superfly/coordBfaas/auth"
 	"github.com/superfly/coordBfaas/coord"
+	"github.com/superfly/coordBfaas/logging"
 	"github.com/superfly/coordBfaas/machines"
 	"github.com/superfly/coordBfaas/machines/pool"
+	"github.com/superfly/coordBfaas/stats"
+	"github.com/superfly/coordBfaas/tracing"
 )
 
 func main() {
-	log.Printf("starting coord")
+	// Get settings from env and the config file.
+	configFile := os.Getenv("CONFIG_FILE")
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogBodies); err != nil {
+		log.Fatalf("log_level: %v", err)
+	}
+	slog.Info("starting coord", "config", configFile)
 
-	// Get settings from env.
-	workerApp := os.Getenv("WORKER_APP")
-	workerImage := os.Getenv("WORKER_IMAGE")
 	flyAuth := os.Getenv("FLY_TOKEN")
-	reqTimeStr := os.Getenv("MAXREQTIME")
-	region := os.Getenv("FLY_REGION")
 	machId := os.Getenv("FLY_MACHINE_ID")
-	poolSizeStr := os.Getenv("POOLSIZE")
-	flyReplay := os.Getenv("FLY_REPLAY") != ""
-
-	log.Printf("checking args")
-	switch workerApp {
-	case "mock":
-		if reqTimeStr == "" {
-			log.Fatalf("need: MAXREQTIME")
-		}
-	default:
-		if workerApp == "" || workerImage == "" || flyAuth == "" || reqTimeStr == "" || machId == "" || poolSizeStr == "" {
-			log.Fatalf("need: WORKER_APP, WORKER_IMAGE, FLY_TOKEN, MAXREQTIME, FLY_MACHINE_ID, POOLSIZE")
-		}
+	mock := cfg.Pool.App == "mock"
+	if !mock && (flyAuth == "" || machId == "") {
+		log.Fatalf("need: FLY_TOKEN, FLY_MACHINE_ID")
 	}
 
-	if region == "" {
-		region = "qmx"
+	opts := []coord.Opt{
+		coord.MaxBodySize(cfg.MaxBodySize),
+		coord.AdminPort(cfg.AdminPort),
+		coord.AuditScripts(cfg.Audit.Scripts),
+	}
+	if cfg.InboundHeaders != nil {
+		opts = append(opts, coord.InboundHeaders(cfg.InboundHeaders...))
+	}
+	if cfg.OutboundHeaders != nil {
+		opts = append(opts, coord.OutboundHeaders(cfg.OutboundHeaders...))
+	}
+	if cfg.AdminPubkey != "" {
+		verifier, err := auth.NewVerifier(cfg.AdminPubkey, machId, 5*time.Minute)
+		if err != nil {
+			log.Fatalf("admin_pubkey: %v", err)
+		}
+		opts = append(opts, coord.AdminAuth(verifier))
 	}
 
-	maxReqTime, err := time.ParseDuration(reqTimeStr)
-	if err != nil {
-		log.Fatalf("MAXREQTIME: %v", err)
+	if cfg.Audit.File != "" {
+		sink, err := coord.NewFileAuditSink(cfg.Audit.File)
+		if err != nil {
+			log.Fatalf("audit.file: %v", err)
+		}
+		defer sink.Close()
+		opts = append(opts, coord.Audit(sink))
+	}
+	if cfg.Audit.Webhook != "" {
+		sink := coord.NewWebhookAuditSink(cfg.Audit.Webhook)
+		defer sink.Close()
+		opts = append(opts, coord.Audit(sink))
 	}
 
-	poolSize, err := strconv.Atoi(poolSizeStr)
+	reg := stats.NewRegistry()
+	opts = append(opts, coord.Registry(reg))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "bfaas-coord", cfg.Tracing)
 	if err != nil {
-		log.Fatalf("POOLSIZE: %v", err)
+		log.Fatalf("tracing.Setup: %v", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	log.Printf("starting pool")
+	slog.Info("starting pool")
+
+	poolOpts := append(cfg.poolOpts(), pool.Registry(reg))
+	if secret := os.Getenv("POOL_SECRET"); secret != "" {
+		poolOpts = append(poolOpts, pool.OwnerKey([]byte(secret)))
+	}
 
 	// Make worker pool.
 	var p pool.Pool
-	if workerApp == "mock" {
-		log.Printf("using mock pool")
+	var api *machines.Api
+	if mock {
+		slog.Info("using mock pool")
 		p = pool.NewMock("go", "run", "cmd/basher/main.go")
 	} else {
-		log.Printf("using fly pool")
-		api := machines.NewInternal(flyAuth)
-		var err error
-		p, err = pool.New(api, machId, workerApp, workerImage,
-			pool.Port(8001), pool.Size(poolSize), pool.Region(region),
-			pool.WorkerTime(2*maxReqTime), pool.LeaseTime(5*time.Minute))
-		if err != nil {
-			log.Fatalf("pool.New: %v", err)
+		slog.Info("using fly pool")
+		api = machines.NewInternal(flyAuth)
+		defaultOpts := append(slices.Clone(poolOpts), pool.Size(cfg.Pool.Size), pool.Region(cfg.Pool.Region),
+			pool.Labels("class", coord.DefaultClass))
+		defaultOpts = append(defaultOpts, cfg.Pool.Guest.opts()...)
+		if as := cfg.Pool.Autoscale; as != nil {
+			defaultOpts = append(defaultOpts, pool.Autoscale(pool.DefaultAutoscale(as.Min, as.Max)))
+		}
+		if regions := cfg.regions(); regions != nil {
+			p, err = pool.NewMultiRegion(api, machId, cfg.Pool.App, cfg.Pool.Image, regions, defaultOpts...)
+			if err != nil {
+				log.Fatalf("pool.NewMultiRegion: %v", err)
+			}
+		} else {
+			p, err = pool.New(api, machId, cfg.Pool.App, cfg.Pool.Image, defaultOpts...)
+			if err != nil {
+				log.Fatalf("pool.New: %v", err)
+			}
 		}
 	}
 	defer p.Close()
 
-	log.Printf("building coord")
-	srv, err := coord.New(p, 8000, maxReqTime, flyReplay)
+	// Make a pool for each worker class, in this region.
+	var classes []coord.WorkerClass
+	classPools := map[string]pool.Pool{}
+	for _, c := range cfg.Classes {
+		var cp pool.Pool
+		if mock {
+			cp = pool.NewMock("go", "run", "cmd/basher/main.go")
+		} else {
+			image := c.Image
+			if image == "" {
+				image = cfg.Pool.Image
+			}
+			classOpts := append(slices.Clone(poolOpts), pool.Size(c.Capacity), pool.Region(cfg.Pool.Region),
+				pool.WorkerTime(c.workerTime()), pool.Labels("class", c.Name))
+			classOpts = append(classOpts, c.Guest.opts()...)
+			cp, err = pool.New(api, machId+"-"+c.Name, cfg.Pool.App, image, classOpts...)
+			if err != nil {
+				log.Fatalf("pool.New: class %s: %v", c.Name, err)
+			}
+		}
+		defer cp.Close()
+		classes = append(classes, coord.WorkerClass{Name: c.Name, Pool: cp, MaxReqTime: c.MaxReqTime})
+		classPools[c.Name] = cp
+	}
+	opts = append(opts, coord.Classes(classes...))
+
+	// Adopt machines left by the last instance before taking requests, so they aren't recreated.
+	if d := cfg.Pool.ReconcileTimeout; d > 0 {
+		slog.Info("reconciling pools", "timeout", d)
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		err := p.Reconcile(ctx)
+		for name, cp := range classPools {
+			if cerr := cp.Reconcile(ctx); cerr != nil {
+				err = errors.Join(err, fmt.Errorf("class %s: %w", name, cerr))
+			}
+		}
+		cancel()
+		if err != nil {
+			slog.Warn("reconcile failed, serving anyway", "err", err)
+		}
+	}
+
+	slog.Info("building coord")
+	srv, err := coord.New(p, cfg.Port, cfg.MaxReqTime, cfg.FlyReplay, opts...)
 	if err != nil {
 		log.Fatalf("coord.New: %v", err)
 	}
 
-	log.Printf("running server")
-	if err := coord.RunWithSignals(srv.Server, time.Second); err != nil {
+	if configFile != "" {
+		r := &reloader{path: configFile, cfg: cfg, srv: srv, pool: p, classes: classPools}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				slog.Info("coord: config: reloading", "path", configFile)
+				if err := r.reload(); err != nil {
+					slog.Error("coord: config: reload failed, keeping current settings", "err", err)
+				}
+			}
+		}()
+	}
+
+	if srv.Admin != nil {
+		slog.Info("running admin server")
+		go func() {
+			if err := srv.Admin.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("admin server", "err", err)
+			}
+		}()
+		defer srv.Admin.Close()
+	}
+
+	slog.Info("running server")
+	// Runs get up to the longest class time limit to finish when draining, before the pools are closed.
+	if err := srv.RunWithDrain(cfg.drainTime()); err != nil {
 		log.Fatalf("RunWithSignals: %v", err)
 	}
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/superfly/coordBfaas/coord"
+	"github.com/superfly/coordBfaas/logging"
+	"github.com/superfly/coordBfaas/machines/pool"
+)
+
+// reloader applies the settings that can change at runtime from a new configuration:
+// log level and bodies, max body size, fly replay,
+// and pool and class capacities. Other changes need a restart.
+type reloader struct {
+	path    string
+	cfg     *config
+	srv     *coord.Server
+	pool    pool.Pool
+	classes map[string]pool.Pool
+}
+
+// reload reads the configuration again and applies what it can.
+// If the new configuration is bad, nothing changes.
+func (r *reloader) reload() error {
+	c, err := loadConfig(r.path)
+	if err != nil {
+		return err
+	}
+
+	old := r.cfg
+	next := old.withRuntime(c)
+	r.apply(old, next)
+	if changed := diffFields(reflect.ValueOf(*next), reflect.ValueOf(*c), ""); len(changed) > 0 {
+		slog.Warn("coord: config: changes need a restart", "fields", strings.Join(changed, ","))
+	}
+	r.cfg = next
+	return nil
+}
+
+// apply makes the runtime changes from old to next.
+func (r *reloader) apply(old, next *config) {
+	logging.SetLevel(next.LogLevel)
+	logging.SetLogBodies(next.LogBodies)
+	if next.MaxBodySize != old.MaxBodySize {
+		r.srv.SetMaxBodySize(next.MaxBodySize)
+	}
+	if next.FlyReplay != old.FlyReplay {
+		r.srv.SetFlyReplay(next.FlyReplay)
+	}
+
+	setCapacity := func(p pool.Pool, name string, n int) {
+		slog.Info("coord: config: set capacity", "class", name, "capacity", n)
+		if err := p.SetCapacity(n); err != nil {
+			slog.Error("coord: config: set capacity", "class", name, "err", err)
+		}
+	}
+	if next.Pool.Size != old.Pool.Size {
+		setCapacity(r.pool, coord.DefaultClass, next.Pool.Size)
+	}
+	if mp, ok := r.pool.(*pool.MultiRegionPool); ok {
+		for i, reg := range next.Pool.Regions {
+			if reg.Capacity != old.Pool.Regions[i].Capacity {
+				slog.Info("coord: config: set region capacity", "region", reg.Region, "capacity", reg.Capacity)
+				if err := mp.SetRegionCapacity(reg.Region, reg.Capacity); err != nil {
+					slog.Error("coord: config: set region capacity", "region", reg.Region, "err", err)
+				}
+			}
+		}
+	}
+	for i, cl := range next.Classes {
+		if cl.Capacity != old.Classes[i].Capacity {
+			setCapacity(r.classes[cl.Name], cl.Name, cl.Capacity)
+		}
+	}
+}
+
+// withRuntime returns a copy of c with the settings from new that can change at runtime.
+// Capacities only change if the regions or classes are otherwise the same.
+func (c *config) withRuntime(new *config) *config {
+	next := *c
+	next.LogLevel, next.LogBodies = new.LogLevel, new.LogBodies
+	next.MaxBodySize, next.FlyReplay = new.MaxBodySize, new.FlyReplay
+
+	if len(c.Pool.Regions) == 0 && len(new.Pool.Regions) == 0 {
+		next.Pool.Size = new.Pool.Size
+	}
+	regionName := func(r regionConfig) string { return r.Region }
+	if slices.Equal(names(c.Pool.Regions, regionName), names(new.Pool.Regions, regionName)) {
+		next.Pool.Regions = new.Pool.Regions
+	}
+	className := func(cl classConfig) string { return cl.Name }
+	if slices.Equal(names(c.Classes, className), names(new.Classes, className)) {
+		next.Classes = slices.Clone(c.Classes)
+		for i := range next.Classes {
+			next.Classes[i].Capacity = new.Classes[i].Capacity
+		}
+	}
+	return &next
+}
+
+func names[T any](xs []T, name func(T) string) []string {
+	var ns []string
+	for _, x := range xs {
+		ns = append(ns, name(x))
+	}
+	return ns
+}
+
+// diffFields returns the yaml names of the fields that differ between structs a and b,
+// looking inside nested structs.
+func diffFields(a, b reflect.Value, prefix string) []string {
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		name := prefix + strings.Split(a.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Kind() == reflect.Struct {
+			changed = append(changed, diffFields(fa, fb, name+".")...)
+		} else if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
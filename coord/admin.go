@@ -0,0 +1,146 @@
+package coord
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/superfly/coordBfaas/auth"
+	"github.com/superfly/coordBfaas/machines/pool"
+)
+
+// AdminAuth enables the admin API on the admin server.
+// Requests must carry an Authorization header that is accepted by v.
+func AdminAuth(v auth.Verifier) Opt {
+	return func(s *Server) { s.adminAuth = v }
+}
+
+// poolState is the admin API's view of a class's pool.
+type poolState struct {
+	Class    string          `json:"class"`
+	Draining bool            `json:"draining"`
+	Metrics  *pool.Metrics   `json:"metrics"`
+	Machines []pool.MachInfo `json:"machines"`
+}
+
+// handleAdmin registers the admin API on mux.
+func (s *Server) handleAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/pool", s.requireAdmin(s.handlePool))
+	mux.HandleFunc("POST /admin/drain", s.requireAdmin(s.handleDrain(true)))
+	mux.HandleFunc("DELETE /admin/drain", s.requireAdmin(s.handleDrain(false)))
+	mux.HandleFunc("POST /admin/capacity", s.requireAdmin(s.handleCapacity))
+	mux.HandleFunc("POST /admin/clean", s.requireAdmin(s.handleClean))
+	mux.HandleFunc("DELETE /admin/machines/{id}", s.requireAdmin(s.handleDiscard))
+}
+
+// requireAdmin rejects requests that are not authorized by the admin verifier.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if err := s.adminAuth(time.Now(), token); err != nil {
+			slog.WarnContext(r.Context(), "coord: admin: unauthorized", "path", r.URL.Path, "client", clientAddr(r))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminClass returns the class that an admin request selects, as for
+// worker requests. If there is no such class, it responds with 404 and returns nil.
+func (s *Server) adminClass(w http.ResponseWriter, r *http.Request) *workerClass {
+	class := s.classFor(r)
+	if class == nil {
+		http.Error(w, "unknown worker class", http.StatusNotFound)
+	}
+	return class
+}
+
+// handlePool reports the state of the pool and each of its machines.
+func (s *Server) handlePool(w http.ResponseWriter, r *http.Request) {
+	class := s.adminClass(w, r)
+	if class == nil {
+		return
+	}
+	writeJSON(w, &poolState{
+		Class:    class.Name,
+		Draining: s.draining.Load(),
+		Metrics:  class.Pool.Metrics(),
+		Machines: class.Pool.Machines(),
+	})
+}
+
+// handleDrain turns draining on or off.
+func (s *Server) handleDrain(drain bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "coord: admin: drain", "draining", drain)
+		s.draining.Store(drain)
+		s.handlePool(w, r)
+	}
+}
+
+// handleCapacity sets the pool capacity to the `n` form value.
+func (s *Server) handleCapacity(w http.ResponseWriter, r *http.Request) {
+	class := s.adminClass(w, r)
+	if class == nil {
+		return
+	}
+	n, err := strconv.Atoi(r.FormValue("n"))
+	if err != nil {
+		http.Error(w, "bad capacity", http.StatusBadRequest)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "coord: admin: set capacity", "class", class.Name, "capacity", n)
+	if err := class.Pool.SetCapacity(n); err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, pool.ErrAutoscaled) {
+			code = http.StatusConflict
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	s.handlePool(w, r)
+}
+
+// handleClean starts a pool cleaning pass.
+func (s *Server) handleClean(w http.ResponseWriter, r *http.Request) {
+	class := s.adminClass(w, r)
+	if class == nil {
+		return
+	}
+	slog.InfoContext(r.Context(), "coord: admin: clean", "class", class.Name)
+	class.Pool.Clean()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleDiscard discards a machine from the pool.
+func (s *Server) handleDiscard(w http.ResponseWriter, r *http.Request) {
+	class := s.adminClass(w, r)
+	if class == nil {
+		return
+	}
+	id := r.PathValue("id")
+	slog.InfoContext(r.Context(), "coord: admin: discard", "class", class.Name, "mach", id)
+	err := class.Pool.DiscardMachine(id)
+	if errors.Is(err, pool.ErrNoMachine) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("coord: admin: write response", "err", err)
+	}
+}
@@ -0,0 +1,237 @@
+package coord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superfly/coordBfaas/logging"
+)
+
+// AuditRecord is a record of one request to run a script, including requests
+// rejected before they reached a worker, which have no Machine.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	RequestId string    `json:"request_id"`
+
+	// Client is the client address, from Fly-Client-IP or the entry Fly's proxy added to X-Forwarded-For.
+	Client string `json:"client"`
+
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Class   string `json:"class"`
+	Machine string `json:"machine,omitempty"`
+
+	ScriptSha256 string `json:"script_sha256"`
+	Script       string `json:"script,omitempty"`
+	ScriptBytes  int64  `json:"script_bytes"`
+
+	// Code is the HTTP status returned to the client, and Status is how the run ended:
+	// ok, timeout or error, or replayed if it was sent to another region.
+	Code   int    `json:"code"`
+	Status string `json:"status"`
+
+	// ExitCode is the script's exit code, if the worker reported one.
+	ExitCode *int  `json:"exit_code,omitempty"`
+	BytesOut int64 `json:"bytes_out"`
+
+	Duration float64 `json:"duration_seconds"`
+}
+
+// AuditSink receives an AuditRecord for each request to run a script.
+type AuditSink interface {
+	Audit(ctx context.Context, rec *AuditRecord) error
+	Close() error
+}
+
+// FileAuditSink appends audit records to a file as JSON lines.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink opens path for appending audit records, creating it if needed.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+// Audit writes rec as a single line.
+func (p *FileAuditSink) Audit(ctx context.Context, rec *AuditRecord) error {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.file.Write(bs); err != nil {
+		return fmt.Errorf("write audit file: %w", err)
+	}
+	return nil
+}
+
+func (p *FileAuditSink) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.file.Close()
+}
+
+// webhookQueueSize is how many records a WebhookAuditSink holds
+// before it starts dropping them.
+const webhookQueueSize = 1024
+
+// WebhookAuditSink posts each audit record as JSON to a URL.
+// Records are queued and sent in the background so that a slow webhook
+// does not hold up responses. Records are dropped if the queue is full.
+type WebhookAuditSink struct {
+	url    string
+	client *http.Client
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan *AuditRecord
+	done   chan struct{}
+}
+
+// NewWebhookAuditSink returns a sink that posts records to url.
+func NewWebhookAuditSink(url string) *WebhookAuditSink {
+	p := &WebhookAuditSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *AuditRecord, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	go p.send()
+	return p
+}
+
+// Audit queues rec to be sent.
+func (p *WebhookAuditSink) Audit(ctx context.Context, rec *AuditRecord) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return fmt.Errorf("audit webhook closed")
+	}
+
+	select {
+	case p.queue <- rec:
+		return nil
+	default:
+		return fmt.Errorf("audit webhook queue full, dropped %s", rec.RequestId)
+	}
+}
+
+// Close sends any queued records and stops the sink.
+func (p *WebhookAuditSink) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	<-p.done
+	return nil
+}
+
+func (p *WebhookAuditSink) send() {
+	defer close(p.done)
+	for rec := range p.queue {
+		if err := p.post(rec); err != nil {
+			slog.Error("coord: audit webhook", "req_id", rec.RequestId, "err", err)
+		}
+	}
+}
+
+func (p *WebhookAuditSink) post(rec *AuditRecord) error {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("bad status %s", resp.Status)
+	}
+	return nil
+}
+
+// clientAddr returns the address of the client that made r.
+// Fly's proxy sets Fly-Client-IP and appends the client to X-Forwarded-For.
+// Clients can set the earlier X-Forwarded-For entries, so only the last one is used.
+func clientAddr(r *http.Request) string {
+	if ip := r.Header.Get("Fly-Client-IP"); ip != "" {
+		return ip
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		last := xff[len(xff)-1]
+		if i := strings.LastIndex(last, ","); i >= 0 {
+			last = last[i+1:]
+		}
+		if last = strings.TrimSpace(last); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusReplayed is the audit status of requests sent to another region with fly-replay.
+const statusReplayed = "replayed"
+
+// newAuditRecord starts an audit record for a request started at start.
+func (s *Server) newAuditRecord(ctx context.Context, start time.Time, r *http.Request) *AuditRecord {
+	return &AuditRecord{
+		Time:      start,
+		RequestId: logging.RequestId(ctx),
+		Client:    clientAddr(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Class:     className(r),
+	}
+}
+
+// auditScript records the script in body in rec.
+func (s *Server) auditScript(ctx context.Context, rec *AuditRecord, body *replayBody) {
+	rec.ScriptSha256, rec.ScriptBytes = body.Sum(), body.Size()
+	if s.auditScripts {
+		bs, err := io.ReadAll(body.Reader())
+		if err != nil {
+			slog.ErrorContext(ctx, "coord: audit: read script", "err", err)
+		}
+		rec.Script = string(bs)
+	}
+}
+
+// audit finishes rec and sends it to each audit sink.
+func (s *Server) audit(ctx context.Context, rec *AuditRecord) {
+	rec.Duration = time.Now().Sub(rec.Time).Seconds()
+	for _, sink := range s.auditSinks {
+		if err := sink.Audit(ctx, rec); err != nil {
+			slog.ErrorContext(ctx, "coord: audit", "err", err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package coord
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestClientAddr(t *testing.T) {
+	cases := []struct {
+		name   string
+		header map[string][]string
+		want   string
+	}{
+		{"remote addr", nil, "192.0.2.1"},
+		{"fly client ip", map[string][]string{"Fly-Client-Ip": {"203.0.113.7"}, "X-Forwarded-For": {"10.0.0.1, 203.0.113.7"}}, "203.0.113.7"},
+		{"proxy entry", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"spoofed entry", map[string][]string{"X-Forwarded-For": {"10.6.6.6, 203.0.113.7"}}, "203.0.113.7"},
+		{"spoofed header", map[string][]string{"X-Forwarded-For": {"10.6.6.6", "203.0.113.7"}}, "203.0.113.7"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			for k, vs := range c.header {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+			assert.Equal(t, c.want, clientAddr(r))
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package coord
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+)
+
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// replayBody is a request body that can be read several times.
+// Small bodies are kept in memory, and larger bodies are spooled
+// to a temp file so that they do not sit in coord memory.
+type replayBody struct {
+	buf  []byte
+	file *os.File
+	size int64
+	sum  string
+}
+
+// newReplayBody reads r into a replayable body, keeping up to memLimit
+// bytes in memory and spooling anything larger to a temp file.
+// It returns ErrBodyTooLarge if r was limited by http.MaxBytesReader
+// and the limit was exceeded.
+func newReplayBody(r io.Reader, memLimit int64) (*replayBody, error) {
+	h := sha256.New()
+	r = io.TeeReader(r, h)
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, memLimit+1)
+	if err != nil && err != io.EOF {
+		return nil, checkBodyErr(err)
+	}
+	if n <= memLimit {
+		return &replayBody{buf: buf.Bytes(), size: n, sum: hex.EncodeToString(h.Sum(nil))}, nil
+	}
+
+	file, err := os.CreateTemp("", "coord-body-*")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &replayBody{file: file}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		b.Close()
+		return nil, err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		b.Close()
+		return nil, checkBodyErr(err)
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.size = size
+	b.sum = hex.EncodeToString(h.Sum(nil))
+	return b, nil
+}
+
+// unwrapWriter returns the server's own writer from under any wrappers.
+// http.MaxBytesReader needs it to close the connection after a body that is too large.
+func unwrapWriter(w http.ResponseWriter) http.ResponseWriter {
+	for {
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return w
+		}
+		w = u.Unwrap()
+	}
+}
+
+// checkBodyErr translates errors from http.MaxBytesReader into ErrBodyTooLarge.
+func checkBodyErr(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return ErrBodyTooLarge
+	}
+	return err
+}
+
+// Size returns the length of the body.
+func (b *replayBody) Size() int64 {
+	return b.size
+}
+
+// Sum returns the hex SHA-256 hash of the body.
+func (b *replayBody) Sum() string {
+	return b.sum
+}
+
+// Reader returns a new reader positioned at the start of the body.
+func (b *replayBody) Reader() io.ReadCloser {
+	if b.file != nil {
+		return io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+	}
+	return io.NopCloser(bytes.NewReader(b.buf))
+}
+
+// Close releases any resources held by the body.
+func (b *replayBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	err := os.Remove(b.file.Name())
+	b.file = nil
+	return err
+}
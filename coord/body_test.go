@@ -0,0 +1,35 @@
+package coord
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/superfly/coordBfaas/stats"
+)
+
+func TestBodyTooLargeCloses(t *testing.T) {
+	s := &Server{reg: stats.NewRegistry()}
+	srv := httptest.NewServer(s.timed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := newReplayBody(http.MaxBytesReader(unwrapWriter(w), r.Body, 8), 4)
+		if errors.Is(err, ErrBodyTooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		body.Close()
+	})))
+	defer srv.Close()
+
+	// A chunked body has no Content-Length, so it is only found to be too large while reading it.
+	body := io.MultiReader(strings.NewReader(strings.Repeat("x", 64)))
+	resp, err := http.Post(srv.URL, "text/plain", body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.True(t, resp.Close, "connection should close after a body that is too large")
+}
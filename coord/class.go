@@ -0,0 +1,58 @@
+package coord
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/superfly/coordBfaas/machines/pool"
+	"github.com/superfly/coordBfaas/stats"
+)
+
+// ClassHeader is the request header that selects a worker class.
+// The `class` query parameter can be used instead.
+const ClassHeader = "Bfaas-Class"
+
+// DefaultClass is the class of requests that do not select one.
+// It uses the pool and time limit given to New.
+const DefaultClass = "default"
+
+// WorkerClass is a named kind of worker, with its own pool and time limit.
+type WorkerClass struct {
+	Name       string
+	Pool       pool.Pool
+	MaxReqTime time.Duration
+}
+
+// workerClass is a WorkerClass and its statistics.
+type workerClass struct {
+	WorkerClass
+	proxy *stats.OutcomeTimer
+}
+
+// Classes adds worker classes that requests can select with ClassHeader
+// or the `class` query parameter.
+// A class named DefaultClass replaces the default class.
+func Classes(classes ...WorkerClass) Opt {
+	return func(s *Server) {
+		for _, c := range classes {
+			s.classes[c.Name] = &workerClass{WorkerClass: c}
+		}
+	}
+}
+
+// className returns the name of the class that r selects.
+func className(r *http.Request) string {
+	name := r.Header.Get(ClassHeader)
+	if name == "" {
+		name = r.URL.Query().Get("class")
+	}
+	if name == "" {
+		name = DefaultClass
+	}
+	return name
+}
+
+// classFor returns the class that r selects, or nil if it is not a known class.
+func (s *Server) classFor(r *http.Request) *workerClass {
+	return s.classes[className(r)]
+}
@@ -0,0 +1,174 @@
+package coord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is how long runs that were terminated by a drain
+// get to report their status to clients before the server is closed.
+const shutdownGrace = time.Second
+
+// run is a request in flight.
+type run struct {
+	reqId  string
+	start  time.Time
+	mach   string
+	cancel context.CancelFunc
+}
+
+// inFlight tracks the requests being handled, so that a drain can wait for them.
+type inFlight struct {
+	mu   sync.Mutex
+	runs map[*run]struct{}
+	idle chan struct{} // closed when runs becomes empty, if anyone is waiting
+}
+
+// add starts tracking a request, returning a context that is cancelled
+// if the request is terminated by a drain.
+func (p *inFlight) add(ctx context.Context, reqId string) (context.Context, *run) {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &run{reqId: reqId, start: time.Now(), cancel: cancel}
+
+	p.mu.Lock()
+	if p.runs == nil {
+		p.runs = make(map[*run]struct{})
+	}
+	p.runs[r] = struct{}{}
+	p.mu.Unlock()
+	return ctx, r
+}
+
+// setMach records the worker a request was given.
+func (p *inFlight) setMach(r *run, machId string) {
+	p.mu.Lock()
+	r.mach = machId
+	p.mu.Unlock()
+}
+
+// done stops tracking a request.
+func (p *inFlight) done(r *run) {
+	p.mu.Lock()
+	delete(p.runs, r)
+	if len(p.runs) == 0 && p.idle != nil {
+		close(p.idle)
+		p.idle = nil
+	}
+	p.mu.Unlock()
+	r.cancel()
+}
+
+// wait waits for all requests to finish, or for ctx to be done.
+func (p *inFlight) wait(ctx context.Context) error {
+	p.mu.Lock()
+	if len(p.runs) == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	if p.idle == nil {
+		p.idle = make(chan struct{})
+	}
+	idle := p.idle
+	p.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// terminate cancels all requests that are still in flight and returns
+// how many there were.
+func (p *inFlight) terminate() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for r := range p.runs {
+		slog.Warn("coord: drain: terminating run", "req_id", r.reqId, "mach", r.mach,
+			"age", time.Now().Sub(r.start))
+		r.cancel()
+	}
+	return len(p.runs)
+}
+
+// Draining returns true if the server is draining.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Drain stops the server from accepting new runs and waits for
+// in-flight runs to finish. If ctx is done first, the remaining runs
+// are terminated and an error reporting how many is returned.
+// New requests are rejected with 503, or replayed elsewhere if
+// fly-replay is enabled, and the admin readiness check fails.
+func (s *Server) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+	slog.Info("coord: drain: waiting for runs")
+
+	if err := s.inFlight.wait(ctx); err == nil {
+		slog.Info("coord: drain: all runs finished")
+		return nil
+	}
+
+	n := s.inFlight.terminate()
+	slog.Warn("coord: drain: terminated runs", "count", n)
+
+	// Give terminated runs a moment to report their status and free their workers.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	s.inFlight.wait(ctx)
+	return fmt.Errorf("drain: terminated %d runs", n)
+}
+
+// handleReady reports whether the server is accepting runs.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ready\n")
+}
+
+// RunWithDrain runs the server until it shuts down or an interrupt signal
+// is received. On interrupt, the server drains for up to drainTime before
+// terminating any remaining runs and shutting down.
+// The server keeps listening while it drains so that new requests are
+// turned away cleanly rather than refused.
+func (s *Server) RunWithDrain(drainTime time.Duration) error {
+	done := make(chan error, 1)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	go func() { done <- s.ListenAndServe() }()
+
+	var err error
+	select {
+	case <-sig:
+		ctx, cancel := context.WithTimeout(context.Background(), drainTime)
+		s.Drain(ctx)
+		cancel()
+
+		ctx, cancel = context.WithTimeout(context.Background(), shutdownGrace)
+		s.Shutdown(ctx)
+		cancel()
+		s.Close()
+		err = <-done
+	case err = <-done:
+		// continue...
+	}
+
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
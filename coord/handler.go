@@ -1,12 +1,11 @@
 package coord
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"regexp"
@@ -14,7 +13,12 @@ import (
 	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/superfly/coordBfaas/basher"
+	"github.com/superfly/coordBfaas/logging"
 	"github.com/superfly/coordBfaas/machines/pool"
+	"github.com/superfly/coordBfaas/tracing"
 )
 
 var client = &http.Client{}
@@ -22,18 +26,30 @@ var client = &http.Client{}
 var retryTimes = 5
 var retryDelay = 20 * time.Millisecond
 
-func copyFlusher(w http.ResponseWriter, r io.Reader) (int, error) {
+// withRequestId gives each request a new request ID, which is returned
+// to the client and passed to the worker.
+func withRequestId(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logging.NewRequestId()
+		w.Header().Set(logging.RequestIdHeader, id)
+		h.ServeHTTP(w, r.WithContext(logging.WithRequestId(r.Context(), id)))
+	})
+}
+
+func copyFlusher(ctx context.Context, w http.ResponseWriter, r io.Reader) (int, error) {
 	flusher, canFlush := w.(http.Flusher)
 	buf := make([]byte, 4096)
 	tot := 0
 	for {
 		n, err := r.Read(buf)
 		if n == 0 {
-			log.Printf("coord: copyFlusher tot=%d n=%d err=%v", tot, n, err)
+			slog.DebugContext(ctx, "coord: copyFlusher", "bytes", tot, "err", err)
 			return tot, err
 		}
 
-		log.Printf("coord: proxying %q", string(buf[:n]))
+		if logging.LogBodies() {
+			slog.DebugContext(ctx, "coord: proxying", "chunk", string(buf[:n]))
+		}
 		n, err = w.Write(buf[:n])
 		tot += n
 		if err != nil {
@@ -45,19 +61,21 @@ func copyFlusher(w http.ResponseWriter, r io.Reader) (int, error) {
 	}
 }
 
-// doWithRetry will retry a request several times if the connection is refused,
-// giving the worker machine some time to start up its http server.
+// doWithRetry will retry a request several times if the connection is refused.
+// Workers have already passed their readiness check when allocated, so this
+// is only a safety net for transient fly proxy errors.
 // Since requests go through fly proxy, we treat ECONNRESET similarly to ECONNREFUSED.
-func doWithRetry(body []byte, req *http.Request) (resp *http.Response, err error) {
+// The body is replayed from the start on each attempt.
+func doWithRetry(body *replayBody, req *http.Request) (resp *http.Response, err error) {
 	delay := retryDelay
 	for i := 0; i < retryTimes; i += 1 {
 		if i > 0 {
-			log.Printf("coord: %v: retrying after %v", err, delay)
+			slog.WarnContext(req.Context(), "coord: retrying worker request", "err", err, "delay", delay)
 			time.Sleep(delay)
 			delay = 2 * delay
 		}
 
-		req.Body = io.NopCloser(bytes.NewBuffer(body))
+		req.Body = body.Reader()
 		resp, err = client.Do(req)
 		if err == nil || !(errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)) {
 			return
@@ -66,22 +84,32 @@ func doWithRetry(body []byte, req *http.Request) (resp *http.Response, err error
 	return
 }
 
-// getWorker returns a worker from the pool. It will try to get one immediately if possible,
+// getWorker returns a worker from the class's pool. It will try to get one immediately if possible,
 // and if it can't get one immediately in the current region, it will fly-replay to another
 // region once in hopes of getting a free worker quicker in another region.
 //
 // If it returns nil, the caller should return immediately, as the request has been
-// handled with an error or sent to another region for handling.
-func (s *Server) getWorker(w http.ResponseWriter, r *http.Request) *pool.Mach {
+// handled with an error or sent to another region for handling, and recorded in rec.
+func (s *Server) getWorker(w http.ResponseWriter, r *http.Request, class *workerClass, rec *AuditRecord) *pool.Mach {
+	if s.draining.Load() {
+		slog.InfoContext(r.Context(), "coord: draining, rejecting request")
+		if s.flyReplay.Load() {
+			w.Header().Set("fly-replay", "elsewhere=true")
+		}
+		rec.Code, rec.Status = http.StatusServiceUnavailable, statusError
+		http.Error(w, "server draining", http.StatusServiceUnavailable)
+		return nil
+	}
+
 	var retriesRemaining int
-	if s.flyReplay {
+	if s.flyReplay.Load() {
 		retriesRemaining = 1
 	}
 
 	// meta has the retries
 	replayMeta := r.Header.Get("fly-replay-src")
 	if replayMeta != "" {
-		log.Printf("coord: replay meta: %v", replayMeta)
+		slog.DebugContext(r.Context(), "coord: replay meta", "meta", replayMeta)
 		matches := regexp.MustCompile(`state=retries-(-?\d+)$`).FindStringSubmatch(replayMeta)
 		if matches != nil {
 			retries, err := strconv.Atoi(matches[0])
@@ -92,15 +120,23 @@ func (s *Server) getWorker(w http.ResponseWriter, r *http.Request) *pool.Mach {
 	}
 
 	waitForMachine := retriesRemaining <= 0
-	worker, err := s.pool.Alloc(context.Background(), waitForMachine)
+	worker, err := class.Pool.Alloc(tracing.Detach(r.Context()), waitForMachine)
+	if errors.Is(err, pool.ErrWorkerBoot) {
+		slog.ErrorContext(r.Context(), "coord: pool.Alloc", "err", err)
+		rec.Code, rec.Status = http.StatusBadGateway, statusError
+		http.Error(w, "worker failed to boot", http.StatusBadGateway)
+		return nil
+	}
 	if err != nil {
-		log.Printf("coord: pool.Alloc: %v", err)
+		slog.ErrorContext(r.Context(), "coord: pool.Alloc", "err", err)
+		rec.Code, rec.Status = http.StatusInternalServerError, statusError
 		http.Error(w, "create worker failed", http.StatusInternalServerError)
 		return nil
 	}
 
 	if worker == nil && retriesRemaining <= 0 {
-		log.Printf("coord: no worker available, out of retries")
+		slog.WarnContext(r.Context(), "coord: no worker available, out of retries")
+		rec.Code, rec.Status = http.StatusServiceUnavailable, statusError
 		http.Error(w, "no worker available", http.StatusServiceUnavailable)
 		return nil
 	}
@@ -108,8 +144,9 @@ func (s *Server) getWorker(w http.ResponseWriter, r *http.Request) *pool.Mach {
 	if worker == nil {
 		retriesRemaining = retriesRemaining - 1
 		// gotta replay
-		log.Printf("coord: no worker available, fly-replay")
+		slog.InfoContext(r.Context(), "coord: no worker available, fly-replay")
 		w.Header().Set("fly-replay", fmt.Sprintf("elsewhere=true;state=retries-%d", retriesRemaining))
+		rec.Code, rec.Status = http.StatusServiceUnavailable, statusReplayed
 		w.WriteHeader(http.StatusServiceUnavailable)
 		w.Write([]byte("no worker available\n"))
 		return nil
@@ -118,76 +155,175 @@ func (s *Server) getWorker(w http.ResponseWriter, r *http.Request) *pool.Mach {
 }
 
 func (s *Server) proxyToWorker(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "coord", "coord.run",
+		trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	ctx, run := s.inFlight.add(ctx, logging.RequestId(ctx))
+	defer s.inFlight.done(run)
+	r = r.WithContext(ctx)
+
 	w.Header().Set("Coord", os.Getenv("FLY_MACHINE_ID"))
-	worker := s.getWorker(w, r)
+	// Requests are audited even if they are rejected before reaching a worker.
+	rec := s.newAuditRecord(ctx, start, r)
+	defer s.audit(ctx, rec)
+
+	class := s.classFor(r)
+	if class == nil {
+		slog.WarnContext(ctx, "coord: unknown worker class", "class", className(r))
+		rec.Code, rec.Status = http.StatusBadRequest, statusError
+		http.Error(w, "unknown worker class", http.StatusBadRequest)
+		return
+	}
+	// Bodies that are too large fail anyway, so don't take a worker for them.
+	if r.ContentLength > s.maxBodySize.Load() {
+		slog.InfoContext(ctx, "coord: request body too large", "content_length", r.ContentLength)
+		rec.Code, rec.Status = http.StatusRequestEntityTooLarge, statusError
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	worker := s.getWorker(w, r, class, rec)
 	if worker == nil {
 		return
 	}
+	s.inFlight.setMach(run, worker.Id)
+	rec.Machine = worker.Id
 
 	defer worker.Free()
 
-	defer func() {
-		for k, v := range s.stats {
-			log.Printf("coord: proxyToWorker: stats %s: %+v", k, v.Stats())
-		}
-	}()
-
-	dtReq := s.stats[statsRequest].Start()
-	defer dtReq.End()
-
-	// We need the body multiple times, read it into memory.
-	body, err := io.ReadAll(r.Body)
+	// We may need the body multiple times, make it replayable.
+	body, err := newReplayBody(http.MaxBytesReader(unwrapWriter(w), r.Body, s.maxBodySize.Load()), s.memBodySize)
 	r.Body.Close()
+	if errors.Is(err, ErrBodyTooLarge) {
+		rec.Code, rec.Status = http.StatusRequestEntityTooLarge, statusError
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
+		slog.ErrorContext(ctx, "coord: read body", "err", err)
+		rec.Code, rec.Status = http.StatusInternalServerError, statusError
 		http.Error(w, "read body failed", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("coord: proxyToWorker %v %q", r.Header, string(body))
+	defer body.Close()
+
+	s.auditScript(ctx, rec, body)
+	slog.InfoContext(ctx, "coord: proxyToWorker", "class", class.Name, "method", r.Method, "path", r.URL.Path,
+		"body_bytes", body.Size(), "client", clientAddr(r))
+	slog.DebugContext(ctx, "coord: request headers", "headers", logging.RedactHeaders(r.Header))
 
 	// Proxy request r to worker.Url with extra headers added.
-	ctx, _ := context.WithTimeout(r.Context(), s.maxReqTime)
+	ctx, cancel := context.WithTimeout(ctx, class.MaxReqTime)
+	defer cancel()
 	method := r.Method
 	url := fmt.Sprintf("%s%s", worker.Url, r.URL.Path)
 	workReq, err := http.NewRequestWithContext(ctx, method, url, nil) // body filled in by doWithRetry
 	if err != nil {
-		log.Printf("coord: NewRequestWithContext: %v", err)
+		slog.ErrorContext(ctx, "coord: NewRequestWithContext", "err", err)
+		rec.Code, rec.Status = http.StatusInternalServerError, statusError
 		http.Error(w, "create worker request failed", http.StatusInternalServerError)
 		return
 	}
 
-	for k, v := range r.Header {
-		workReq.Header[k] = v
-	}
+	copyHeaders(workReq.Header, r.Header, s.inbound)
 	workReq.Header.Set("fly-force-instance-id", worker.Id)
+	workReq.Header.Set(logging.RequestIdHeader, logging.RequestId(ctx))
+	workReq.ContentLength = body.Size()
 	workReq.URL.RawQuery = r.URL.RawQuery
 
-	log.Printf("coord: making request for %v to worker %v: %v %v", s.maxReqTime, worker.Id, method, workReq.URL.String())
-	dtProxy := s.stats[statsProxy].Start()
+	slog.InfoContext(ctx, "coord: making worker request", "worker", worker.Id, "timeout", class.MaxReqTime, "method", method, "url", workReq.URL.String())
+	dtProxy := class.proxy.Start()
+	proxyCtx, proxySpan := tracing.Start(ctx, "coord", "coord.proxy", trace.WithSpanKind(trace.SpanKindClient))
+	tracing.Inject(proxyCtx, workReq.Header)
 	workResp, err := doWithRetry(body, workReq)
-	dtProxy.End()
+	tracing.End(proxySpan, err)
+	dtProxy.End(err)
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
-			// Do not output anything, we may have already outputted some.
-			log.Printf("coord: client.Do timed out")
+			// Nothing has been proxied yet, so we can still report a status.
+			slog.WarnContext(ctx, "coord: client.Do timed out")
+			rec.Code, rec.Status = http.StatusGatewayTimeout, statusTimeout
+			w.Header().Set(StatusTrailer, statusTimeout)
+			http.Error(w, "worker timed out", http.StatusGatewayTimeout)
 			return
 		}
 
-		log.Printf("coord: client.Do: %v", err)
+		slog.ErrorContext(ctx, "coord: client.Do", "err", err)
+		rec.Code, rec.Status = http.StatusInternalServerError, statusError
+		w.Header().Set(StatusTrailer, statusError)
 		http.Error(w, "make worker request failed", http.StatusInternalServerError)
 		return
 	}
 	defer workResp.Body.Close()
 
-	// proxy response workResp back to w.
+	// Fail closed if the response did not come from our worker, rather than
+	// risk sending someone else's output to the client.
 	if id := workResp.Header.Get("worker"); id != worker.Id {
-		log.Printf("coord: warning: request went to %v not %v", id, worker.Id)
+		s.wrongWorker(ctx, class, worker.Id, id)
+		worker.Discard(fmt.Sprintf("response came from %q", id))
+		rec.Code, rec.Status = http.StatusBadGateway, statusError
+		http.Error(w, "worker mismatch", http.StatusBadGateway)
+		return
 	}
 
-	for k, v := range workResp.Header {
-		w.Header()[k] = v
+	// proxy response workResp back to w.
+	// Raw responses announce a trailer so clients can tell a timeout
+	// or worker failure from a normal end of output.
+	copyHeaders(w.Header(), workResp.Header, s.outbound)
+	sse := isEventStream(workResp.Header)
+	if !sse {
+		w.Header().Set("Trailer", StatusTrailer)
 	}
 	w.WriteHeader(workResp.StatusCode)
-	copyFlusher(w, workResp.Body)
-	log.Printf("coord: finished proxying response")
+	n, err := copyFlusher(ctx, w, workResp.Body)
+	status := proxyStatus(ctx, err)
+	switch status {
+	case statusTimeout:
+		writeStatus(w, sse, status, fmt.Sprintf("request exceeded %v", class.MaxReqTime))
+	case statusError:
+		writeStatus(w, sse, status, "worker response failed")
+	default:
+		writeStatus(w, sse, status, "")
+	}
+	slog.InfoContext(ctx, "coord: finished proxying response", "status", status, "code", workResp.StatusCode, "bytes", n)
+
+	rec.Code, rec.Status, rec.BytesOut = workResp.StatusCode, status, int64(n)
+	if exit, err := strconv.Atoi(workResp.Trailer.Get(basher.ExitCodeTrailer)); err == nil {
+		rec.ExitCode = &exit
+	}
 	workResp.Body.Close()
 }
+
+// wrongWorker handles a response for worker want that came from machine got.
+// If got is one of our machines, in any class, it ran someone else's request, so it is discarded too.
+// Otherwise nothing we manage answered, which is counted so it can be alerted on.
+func (s *Server) wrongWorker(ctx context.Context, class *workerClass, want, got string) {
+	source := "unknown"
+	if owner := s.machineOwner(class, got); owner != nil {
+		source = "pool"
+		slog.ErrorContext(ctx, "coord: request went to another pool machine, discarding it", "got", got, "want", want, "owner", owner.Name)
+		if err := owner.Pool.DiscardMachine(got); err != nil {
+			slog.ErrorContext(ctx, "coord: discard machine", "mach", got, "err", err)
+		}
+	} else {
+		slog.ErrorContext(ctx, "coord: request went to unknown machine", "got", got, "want", want)
+	}
+	s.reg.Counter("bfaas_coord_wrong_worker_total", "Responses that came from a machine other than the allocated worker",
+		"class", class.Name, "source", source).Inc()
+}
+
+// machineOwner returns the class whose pool owns machine id, checking class first, or nil if none does.
+func (s *Server) machineOwner(class *workerClass, id string) *workerClass {
+	if id == "" {
+		return nil
+	}
+	if class.Pool.Owns(id) {
+		return class
+	}
+	for _, c := range s.classes {
+		if c.Pool.Owns(id) {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package coord
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/superfly/coordBfaas/machines/pool"
+	"github.com/superfly/coordBfaas/stats"
+)
+
+// ownsPool is a pool that owns machs, and records the machines discarded from it.
+type ownsPool struct {
+	pool.Pool
+	machs     []string
+	discarded []string
+}
+
+func (p *ownsPool) Owns(machId string) bool {
+	for _, m := range p.machs {
+		if m == machId {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ownsPool) DiscardMachine(machId string) error {
+	p.discarded = append(p.discarded, machId)
+	return nil
+}
+
+func TestWrongWorker(t *testing.T) {
+	small := &ownsPool{machs: []string{"s1", "s2"}}
+	large := &ownsPool{machs: []string{"l1"}}
+	s := &Server{reg: stats.NewRegistry(), classes: map[string]*workerClass{
+		"small": {WorkerClass: WorkerClass{Name: "small", Pool: small}},
+		"large": {WorkerClass: WorkerClass{Name: "large", Pool: large}},
+	}}
+	ctx := context.Background()
+
+	s.wrongWorker(ctx, s.classes["small"], "s1", "s2")
+	s.wrongWorker(ctx, s.classes["small"], "s1", "l1")
+	s.wrongWorker(ctx, s.classes["small"], "s1", "x9")
+	assert.Equal(t, []string{"s2"}, small.discarded)
+	assert.Equal(t, []string{"l1"}, large.discarded)
+
+	counted := func(source string) int64 {
+		return s.reg.Counter("bfaas_coord_wrong_worker_total", "", "class", "small", "source", source).Value()
+	}
+	assert.Equal(t, int64(2), counted("pool"))
+	assert.Equal(t, int64(1), counted("unknown"))
+}
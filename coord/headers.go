@@ -0,0 +1,75 @@
+package coord
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopHeaders are hop-by-hop headers from RFC 7230 section 6.1,
+// which are never forwarded by a proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+var defaultInboundHeaders = []string{
+	"Accept",
+	"Content-Type",
+	"User-Agent",
+}
+
+var defaultOutboundHeaders = []string{
+	"Cache-Control",
+	"Content-Type",
+	"Worker",
+}
+
+// headerSet is a set of canonical header names.
+type headerSet map[string]bool
+
+func newHeaderSet(names ...string) headerSet {
+	set := make(headerSet)
+	for _, name := range names {
+		set[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+	return set
+}
+
+// hopByHop returns the set of hop-by-hop headers in h, including any
+// named in its Connection header.
+func hopByHop(h http.Header) headerSet {
+	set := newHeaderSet(hopHeaders...)
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				set[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+	return set
+}
+
+// isFlyHeader returns true for headers that are interpreted by fly-proxy.
+func isFlyHeader(k string) bool {
+	return strings.HasPrefix(strings.ToLower(k), "fly-")
+}
+
+// copyHeaders copies headers from src to dst that are in allow.
+// Hop-by-hop headers and fly-proxy headers are never copied.
+func copyHeaders(dst, src http.Header, allow headerSet) {
+	hop := hopByHop(src)
+	for k, v := range src {
+		k = http.CanonicalHeaderKey(k)
+		if hop[k] || isFlyHeader(k) || !allow[k] {
+			continue
+		}
+		dst[k] = append([]string(nil), v...)
+	}
+}
@@ -1,12 +1,14 @@
 package coord
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
+
+	"github.com/superfly/coordBfaas/stats"
 )
 
 type limEntry struct {
@@ -22,6 +24,8 @@ type Limiter struct {
 
 	mu  sync.Mutex
 	lim map[string]*limEntry
+
+	rejected *stats.Counter
 }
 
 func newLimiter(r rate.Limit, b int, bucketLife time.Duration) *Limiter {
@@ -30,6 +34,8 @@ func newLimiter(r rate.Limit, b int, bucketLife time.Duration) *Limiter {
 		b:    b,
 		lim:  make(map[string]*limEntry),
 		life: bucketLife,
+
+		rejected: &stats.Counter{},
 	}
 
 	return lim
@@ -72,8 +78,9 @@ type Handler func(w http.ResponseWriter, req *http.Request)
 func (p *Limiter) middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		k := req.Header.Get("X-Forwarded-For")
-		log.Printf("rate limit by %q", k)
 		if !p.Allow(k) {
+			slog.InfoContext(req.Context(), "coord: rate limited", "client", k)
+			p.rejected.Inc()
 			http.Error(w, http.StatusText(429), http.StatusTooManyRequests)
 			return
 		}
@@ -0,0 +1,81 @@
+package coord
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/superfly/coordBfaas/stats"
+)
+
+// register registers the server's statistics in reg.
+func (s *Server) register(reg *stats.Registry) {
+	for _, c := range s.classes {
+		c.proxy = reg.OutcomeTimer("bfaas_coord_proxy_seconds", "Time spent making the worker request", "class", c.Name)
+	}
+	if s.limiter != nil {
+		s.limiter.rejected = reg.Counter("bfaas_coord_limiter_rejections_total", "Requests rejected by the rate limiter")
+	}
+}
+
+// statusWriter records the status code written to a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(bs []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(bs)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController and unwrapWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// timed records the duration of each request, labelled by status code.
+func (s *Server) timed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		code := sw.code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		s.reg.Timer("bfaas_coord_request_seconds", "Time spent handling requests", "code", strconv.Itoa(code)).
+			Add(time.Now().Sub(start).Seconds())
+	})
+}
+
+// handleMetrics serves all registered statistics in Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.reg.WritePrometheus(w)
+}
+
+// handleMetricsJSON serves all registered statistics as JSON.
+func (s *Server) handleMetricsJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.reg.WriteJSON(w); err != nil {
+		slog.Error("coord: metrics", "err", err)
+	}
+}
@@ -0,0 +1,21 @@
+package coord
+
+import (
+	"log/slog"
+)
+
+// Settings that can change while the server runs.
+// Changes apply to requests that start after they are made.
+
+// SetMaxBodySize changes the largest request body that will be proxied to a worker.
+func (s *Server) SetMaxBodySize(n int64) {
+	slog.Info("coord: set max body size", "bytes", n)
+	s.maxBodySize.Store(n)
+}
+
+// SetFlyReplay changes whether requests are replayed to another region
+// when no worker is free.
+func (s *Server) SetFlyReplay(enable bool) {
+	slog.Info("coord: set fly replay", "enabled", enable)
+	s.flyReplay.Store(enable)
+}
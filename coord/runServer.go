@@ -23,8 +23,9 @@ func RunWithSignals(s *http.Server, graceTime time.Duration) error {
 	var err error
 	select {
 	case <-sig:
-		ctx, _ := context.WithTimeout(context.Background(), graceTime)
+		ctx, cancel := context.WithTimeout(context.Background(), graceTime)
 		s.Shutdown(ctx)
+		cancel()
 		s.Close()
 		err = <-done
 	case err = <-done:
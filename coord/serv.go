@@ -3,36 +3,113 @@ package coord
 import (
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/time/rate"
+
+	"github.com/superfly/coordBfaas/auth"
 	"github.com/superfly/coordBfaas/machines/pool"
 	"github.com/superfly/coordBfaas/stats"
 )
 
-const (
-	statsRequest = "request"
-	statsProxy   = "proxy"
-)
-
 type Server struct {
 	*http.Server
-	maxReqTime time.Duration
-	flyReplay  bool
-	pool       pool.Pool
 
-	stats map[string]*stats.Collector
+	// Admin serves /metrics, /metrics.json and /readyz on a separate port, if enabled
+	// with AdminPort, and the admin API if enabled with AdminAuth.
+	Admin *http.Server
+
+	flyReplay   atomic.Bool
+	classes     map[string]*workerClass
+	maxBodySize atomic.Int64
+	memBodySize int64
+	inbound     headerSet
+	outbound    headerSet
+	adminPort   int
+	adminAuth   auth.Verifier
+	limiter     *Limiter
+	draining    atomic.Bool
+	inFlight    inFlight
+
+	auditSinks   []AuditSink
+	auditScripts bool
+
+	reg *stats.Registry
+}
+
+type Opt func(*Server)
+
+// MaxBodySize sets the largest request body that will be proxied to a worker.
+// Larger requests are rejected with 413.
+func MaxBodySize(n int64) Opt {
+	return func(s *Server) { s.maxBodySize.Store(n) }
+}
+
+// MemBodySize sets the largest request body that will be held in memory.
+// Larger requests are spooled to a temp file.
+func MemBodySize(n int64) Opt {
+	return func(s *Server) { s.memBodySize = n }
+}
+
+// InboundHeaders sets the client request headers that are forwarded to workers.
+// Hop-by-hop and fly-* headers are never forwarded.
+func InboundHeaders(names ...string) Opt {
+	return func(s *Server) { s.inbound = newHeaderSet(names...) }
+}
+
+// OutboundHeaders sets the worker response headers that are returned to clients.
+// Hop-by-hop and fly-* headers are never returned.
+func OutboundHeaders(names ...string) Opt {
+	return func(s *Server) { s.outbound = newHeaderSet(names...) }
 }
 
-func New(pool pool.Pool, port int, maxReqTime time.Duration, enableFlyReplay bool) (*Server, error) {
+// AdminPort enables the admin server on port.
+func AdminPort(port int) Opt {
+	return func(s *Server) { s.adminPort = port }
+}
+
+// RateLimit limits each client, keyed by X-Forwarded-For, to r requests
+// per second with bursts of up to b requests.
+func RateLimit(r rate.Limit, b int) Opt {
+	return func(s *Server) { s.limiter = newLimiter(r, b, time.Minute) }
+}
+
+// Audit adds sinks that receive an AuditRecord for each script run.
+// The caller is responsible for closing the sinks after the server has stopped.
+func Audit(sinks ...AuditSink) Opt {
+	return func(s *Server) { s.auditSinks = append(s.auditSinks, sinks...) }
+}
+
+// AuditScripts includes the full script in audit records,
+// rather than only its hash.
+func AuditScripts(enable bool) Opt {
+	return func(s *Server) { s.auditScripts = enable }
+}
+
+// Registry sets the registry the server registers its statistics in,
+// and which is served from the admin server.
+func Registry(reg *stats.Registry) Opt {
+	return func(s *Server) { s.reg = reg }
+}
+
+func New(pool pool.Pool, port int, maxReqTime time.Duration, enableFlyReplay bool, opts ...Opt) (*Server, error) {
 	server := &Server{
-		pool:       pool,
-		maxReqTime: maxReqTime,
-		flyReplay:  enableFlyReplay,
-		stats: map[string]*stats.Collector{
-			statsRequest: stats.New(),
-			statsProxy:   stats.New(),
-		},
+		memBodySize: 64 * 1024,
+		inbound:     newHeaderSet(defaultInboundHeaders...),
+		outbound:    newHeaderSet(defaultOutboundHeaders...),
+		reg:         stats.NewRegistry(),
+	}
+	server.flyReplay.Store(enableFlyReplay)
+	server.maxBodySize.Store(10 * 1024 * 1024)
+	server.classes = map[string]*workerClass{
+		DefaultClass: {WorkerClass: WorkerClass{Name: DefaultClass, Pool: pool, MaxReqTime: maxReqTime}},
+	}
+
+	for _, opt := range opts {
+		opt(server)
 	}
+	server.register(server.reg)
 
 	server.Server = &http.Server{
 		Addr:        fmt.Sprintf(":%d", port),
@@ -40,7 +117,28 @@ func New(pool pool.Pool, port int, maxReqTime time.Duration, enableFlyReplay boo
 		// Not setting write timeout, but we're managing request times.
 		//WriteTimeout:   maxReqTime,
 		MaxHeaderBytes: 4096,
-		Handler:        http.HandlerFunc(server.proxyToWorker),
+		Handler:        server.timed(http.HandlerFunc(server.proxyToWorker)),
+	}
+	if server.limiter != nil {
+		server.Server.Handler = server.limiter.middleware(server.Server.Handler)
+	}
+	server.Server.Handler = withRequestId(server.Server.Handler)
+
+	if server.adminPort != 0 {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /metrics", server.handleMetrics)
+		mux.HandleFunc("GET /metrics.json", server.handleMetricsJSON)
+		mux.HandleFunc("GET /readyz", server.handleReady)
+		if server.adminAuth != nil {
+			server.handleAdmin(mux)
+		}
+		server.Admin = &http.Server{
+			Addr:           fmt.Sprintf(":%d", server.adminPort),
+			ReadTimeout:    10 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			MaxHeaderBytes: 4096,
+			Handler:        mux,
+		}
 	}
 	return server, nil
 }
@@ -0,0 +1,59 @@
+package coord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// StatusTrailer is the trailer, or header if nothing was proxied yet,
+// that reports how a raw response ended.
+const StatusTrailer = "Bfaas-Status"
+
+const (
+	statusOk      = "ok"
+	statusTimeout = "timeout"
+	statusError   = "error"
+)
+
+// isEventStream returns true if the header describes an SSE response.
+func isEventStream(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+}
+
+// proxyStatus returns the status to report for a proxied response
+// that ended with err while running under ctx.
+func proxyStatus(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return statusTimeout
+	case err == nil || errors.Is(err, io.EOF):
+		return statusOk
+	default:
+		return statusError
+	}
+}
+
+// writeStatus reports status at the end of a proxied response.
+// SSE responses get a final event for anything other than success,
+// and raw responses get the status in the StatusTrailer trailer,
+// which must have been announced before the header was written.
+func writeStatus(w http.ResponseWriter, sse bool, status, msg string) {
+	if !sse {
+		w.Header().Set(StatusTrailer, status)
+		return
+	}
+
+	if status == statusOk {
+		return
+	}
+	bs, _ := json.Marshal(map[string]string{"message": msg})
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", status, string(bs))
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -11,3 +11,14 @@ func TestNilErr(t *testing.T) {
 	var err error
 	assert.False(t, ErrorIsStatus(err, http.StatusPreconditionFailed))
 }
+
+func TestNextLink(t *testing.T) {
+	h := http.Header{}
+	assert.Equal(t, "", NextLink(h))
+
+	h.Add("Link", `</v1/things?cursor=a>; rel="prev", </v1/things?cursor=c>; rel="next"`)
+	assert.Equal(t, "/v1/things?cursor=c", NextLink(h))
+
+	h = http.Header{"Link": {`<https://api.example.com/v1/things?cursor=d>; rel=next`}}
+	assert.Equal(t, "https://api.example.com/v1/things?cursor=d", NextLink(h))
+}
@@ -10,6 +10,12 @@ import (
 	"net/http"
 	"net/url"
 	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/superfly/coordBfaas/tracing"
 )
 
 // HttpError is an error indicating a status code that does not indicate success.
@@ -35,13 +41,14 @@ type Req struct {
 	client  *http.Client
 	baseUrl string
 
-	method   string
-	path     string
-	header   http.Header
-	qs       url.Values
-	reqBody  interface{}
-	respBody interface{}
-	okCodes  []int
+	method     string
+	path       string
+	header     http.Header
+	qs         url.Values
+	reqBody    interface{}
+	respBody   interface{}
+	respHeader *http.Header
+	okCodes    []int
 }
 
 type ReqOpt func(*Req)
@@ -93,6 +100,44 @@ func ReqRespBody(x interface{}) ReqOpt {
 	return func(p *Req) { p.respBody = x }
 }
 
+// ReqRespHeader sets where to store the response's headers.
+func ReqRespHeader(h *http.Header) ReqOpt {
+	return func(p *Req) { p.respHeader = h }
+}
+
+// ReqLink points the request at link, such as a next page link from NextLink,
+// replacing its path and query. The link's scheme and host are ignored.
+func ReqLink(link string) ReqOpt {
+	return func(p *Req) {
+		u, err := url.Parse(link)
+		if err != nil {
+			p.path = link
+			return
+		}
+		p.path, p.qs = u.Path, u.Query()
+	}
+}
+
+// NextLink returns the target of the `rel="next"` link in h, or "" if there is none.
+func NextLink(h http.Header) string {
+	for _, v := range h.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			target = strings.TrimSpace(target)
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(k, "rel") && slices.Contains(strings.Fields(strings.Trim(v, `"`)), "next") {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
 // ReqOkCodes sets the list of http status codes that indicate success.
 func OkCodes(codes ...int) ReqOpt {
 	return func(p *Req) { p.okCodes = codes }
@@ -101,7 +146,12 @@ func OkCodes(codes ...int) ReqOpt {
 // Do performs the request, returning any errors.
 // If the request has a response body and there are no errors,
 // the response's body is parsed into it.
-func (p *Req) Do(ctx context.Context) error {
+// The request is traced as a child of any span in ctx, and
+// the trace context is sent in a traceparent header.
+func (p *Req) Do(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "japi", p.method+" "+p.path, trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { tracing.End(span, err) }()
+
 	url := p.baseUrl + p.path
 	fullUrl := url
 	if len(p.qs) > 0 {
@@ -126,12 +176,18 @@ func (p *Req) Do(ctx context.Context) error {
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	tracing.Inject(ctx, req.Header)
 
 	resp, err := p.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("%s: client.Do: %w", url, err)
 	}
+	defer resp.Body.Close()
 
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if p.respHeader != nil {
+		*p.respHeader = resp.Header
+	}
 	ok := slices.Contains(p.okCodes, resp.StatusCode)
 	if !ok {
 		bs, _ := io.ReadAll(resp.Body)
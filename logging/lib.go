@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// RequestIdHeader carries the request ID from coord to workers and back to clients.
+const RequestIdHeader = "Bfaas-Request-Id"
+
+const redacted = "REDACTED"
+
+// sensitiveHeaders are never logged.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":           true,
+	"Proxy-Authorization":     true,
+	"Cookie":                  true,
+	"Set-Cookie":              true,
+	"Fly-Machine-Lease-Nonce": true,
+}
+
+var logBodies atomic.Bool
+
+// level is the level of the default logger, which SetLevel changes.
+var level slog.LevelVar
+
+type ctxKey struct{}
+
+// Setup installs a default slog logger that writes text to stderr
+// at level or above, and adds the request ID from the context to each record.
+// Level is one of debug, info, warn or error, and defaults to info.
+// If bodies is true, request and response bodies may be logged at debug level.
+func Setup(lvl string, bodies bool) error {
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+
+	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &level})
+	slog.SetDefault(slog.New(&ctxHandler{h}))
+	logBodies.Store(bodies)
+	return nil
+}
+
+// SetLevel changes the level of the logger installed by Setup.
+// Level is one of debug, info, warn or error, and defaults to info.
+func SetLevel(lvl string) error {
+	var l slog.Level
+	if lvl != "" {
+		if err := l.UnmarshalText([]byte(lvl)); err != nil {
+			return fmt.Errorf("bad log level %q: %w", lvl, err)
+		}
+	}
+	level.Set(l)
+	return nil
+}
+
+// SetLogBodies changes whether request and response bodies may be logged.
+func SetLogBodies(bodies bool) {
+	logBodies.Store(bodies)
+}
+
+// LogBodies returns true if request and response bodies may be logged.
+func LogBodies() bool {
+	return logBodies.Load()
+}
+
+// NewRequestId returns a new random request ID.
+func NewRequestId() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// WithRequestId returns ctx carrying request ID id.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// RequestId returns the request ID carried by ctx, if any.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// RedactHeaders returns a copy of h that is safe to log,
+// with the values of sensitive headers replaced.
+func RedactHeaders(h http.Header) http.Header {
+	r := make(http.Header, len(h))
+	for k, v := range h {
+		if sensitiveHeaders[http.CanonicalHeaderKey(k)] || strings.Contains(strings.ToLower(k), "token") {
+			r[k] = []string{redacted}
+			continue
+		}
+		r[k] = v
+	}
+	return r
+}
+
+// ctxHandler adds the request ID from the context to records.
+type ctxHandler struct {
+	slog.Handler
+}
+
+func (h *ctxHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		r.AddAttrs(slog.String("req_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ctxHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ctxHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ctxHandler) WithGroup(name string) slog.Handler {
+	return &ctxHandler{h.Handler.WithGroup(name)}
+}
@@ -93,6 +93,10 @@ func TestApi(t *testing.T) {
 	assert.Equal(t, m.Id, mach.Id)
 	assert.Equal(t, m.State, "started")
 
+	machs, err = api.List(ctx, appName, ListRegion("qmx"), ListState("stopped"))
+	assert.NoError(t, err)
+	assert.Zero(t, findMach(machs, mach.Id))
+
 	// Stop and restart
 	ok, err = api.Stop(ctx, appName, mach.Id)
 	assert.NoError(t, err)
@@ -0,0 +1,250 @@
+package machines
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type MachineConfig struct {
+	Init        Init              `json:"init"`
+	Env         map[string]string `json:"env,omitempty"`
+	Metadata    map[string]string `json:"metadata"`
+	Services    []Service         `json:"services"`
+	Checks      map[string]Check  `json:"checks,omitempty"`
+	Image       string            `json:"image"`
+	AutoDestroy bool              `json:"auto_destroy"`
+	Restart     Restart           `json:"restart"`
+	Guest       Guest             `json:"guest"`
+	Files       []File            `json:"files,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	DNS         *DNS              `json:"dns,omitempty"`
+	Processes   []Process         `json:"processes,omitempty"`
+	StopConfig  *StopConfig       `json:"stop_config,omitempty"`
+
+	// Standbys are the IDs of machines this machine stands by for.
+	Standbys []string `json:"standbys,omitempty"`
+}
+
+type Init struct {
+	Exec       []string `json:"exec"`
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	Cmd        []string `json:"cmd,omitempty"`
+	Tty        bool     `json:"tty,omitempty"`
+	SwapSizeMb int      `json:"swap_size_mb,omitempty"`
+	KernelArgs []string `json:"kernel_args,omitempty"`
+}
+
+type Service struct {
+	Protocol           string       `json:"protocol"`
+	InternalPort       int          `json:"internal_port"`
+	Autostop           Autostop     `json:"autostop,omitempty"`
+	Autostart          bool         `json:"autostart"`
+	MinMachinesRunning int          `json:"min_machines_running"`
+	Ports              []Port       `json:"ports,omitempty"`
+	Checks             []Check      `json:"checks,omitempty"`
+	Concurrency        *Concurrency `json:"concurrency,omitempty"`
+
+	// ForceInstanceKey routes all of the service's requests to one machine.
+	ForceInstanceKey         string `json:"force_instance_key,omitempty"`
+	ForceInstanceDescription string `json:"force_instance_description,omitempty"`
+}
+
+// Autostop is what the proxy does with a service's machines when they are idle.
+// The API also takes a bool, which reads as AutostopStop or AutostopOff.
+type Autostop string
+
+const (
+	AutostopOff     Autostop = "off"
+	AutostopStop    Autostop = "stop"
+	AutostopSuspend Autostop = "suspend"
+)
+
+func (a *Autostop) UnmarshalJSON(bs []byte) error {
+	var b bool
+	if err := json.Unmarshal(bs, &b); err == nil {
+		*a = AutostopOff
+		if b {
+			*a = AutostopStop
+		}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return fmt.Errorf("autostop: want bool or string, got %s", bs)
+	}
+	*a = Autostop(s)
+	return nil
+}
+
+type Concurrency struct {
+	Type      string `json:"type,omitempty"` // connections/requests
+	HardLimit int    `json:"hard_limit,omitempty"`
+	SoftLimit int    `json:"soft_limit,omitempty"`
+}
+
+// Port is a port the proxy listens on for a service. It is either a single Port,
+// or a range from StartPort to EndPort.
+type Port struct {
+	Port              int                `json:"port,omitempty" toml:"port,omitempty"`
+	StartPort         int                `json:"start_port,omitempty" toml:"start_port,omitempty"`
+	EndPort           int                `json:"end_port,omitempty" toml:"end_port,omitempty"`
+	Handlers          []string           `json:"handlers,omitempty" toml:"handlers,omitempty"`
+	ForceHTTPS        bool               `json:"force_https,omitempty" toml:"force_https,omitempty"`
+	TLSOptions        *TLSOptions        `json:"tls_options,omitempty" toml:"tls_options,omitempty"`
+	HTTPOptions       *HTTPOptions       `json:"http_options,omitempty" toml:"http_options,omitempty"`
+	ProxyProtoOptions *ProxyProtoOptions `json:"proxy_proto_options,omitempty" toml:"proxy_proto_options,omitempty"`
+}
+
+type TLSOptions struct {
+	Alpn              []string `json:"alpn,omitempty" toml:"alpn,omitempty"`
+	Versions          []string `json:"versions,omitempty" toml:"versions,omitempty"`
+	DefaultSelfSigned bool     `json:"default_self_signed,omitempty" toml:"default_self_signed,omitempty"`
+}
+
+type HTTPOptions struct {
+	Compress           bool                 `json:"compress,omitempty" toml:"compress,omitempty"`
+	Response           *HTTPResponseOptions `json:"response,omitempty" toml:"response,omitempty"`
+	H2Backend          bool                 `json:"h2_backend,omitempty" toml:"h2_backend,omitempty"`
+	IdleTimeout        int                  `json:"idle_timeout,omitempty" toml:"idle_timeout,omitempty"`
+	HeadersReadTimeout int                  `json:"headers_read_timeout,omitempty" toml:"headers_read_timeout,omitempty"`
+}
+
+type HTTPResponseOptions struct {
+	// Headers are set on responses. A value may be a string, a list of strings, or false to remove the header.
+	Headers  map[string]any `json:"headers,omitempty" toml:"headers,omitempty"`
+	Pristine bool           `json:"pristine,omitempty" toml:"pristine,omitempty"`
+}
+
+type ProxyProtoOptions struct {
+	Version string `json:"version,omitempty" toml:"version,omitempty"` // v1/v2
+}
+
+// Check is a health check, of a service or of the machine.
+type Check struct {
+	Type          string       `json:"type,omitempty"` // tcp/http
+	Port          int          `json:"port,omitempty"`
+	Interval      Duration     `json:"interval,omitempty"`
+	Timeout       Duration     `json:"timeout,omitempty"`
+	GracePeriod   Duration     `json:"grace_period,omitempty"`
+	Method        string       `json:"method,omitempty"`
+	Path          string       `json:"path,omitempty"`
+	Protocol      string       `json:"protocol,omitempty"` // http/https
+	TLSSkipVerify bool         `json:"tls_skip_verify,omitempty"`
+	TLSServerName string       `json:"tls_server_name,omitempty"`
+	Headers       []HTTPHeader `json:"headers,omitempty"`
+}
+
+type HTTPHeader struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// Duration is a time.Duration that the API writes as a string, such as "10s".
+// It also reads a number of nanoseconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+	var ns int64
+	if err := json.Unmarshal(bs, &ns); err == nil {
+		*d = Duration(ns)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return fmt.Errorf("duration: want string or number, got %s", bs)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("duration: %w", err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+type Restart struct {
+	Policy     string `json:"policy"` // no/always/on-failure
+	MaxRetries int    `json:"max_retries,omitempty"`
+}
+
+type Guest struct {
+	CpuKind  string `json:"cpu_kind"`
+	Cpus     int    `json:"cpus"`
+	MemoryMb int    `json:"memory_mb"`
+}
+
+// File is written to GuestPath in the machine, from RawValue (base64 encoded) or from an app secret.
+type File struct {
+	GuestPath  string `json:"guest_path"`
+	RawValue   string `json:"raw_value,omitempty"`
+	SecretName string `json:"secret_name,omitempty"`
+	Mode       uint32 `json:"mode,omitempty"`
+}
+
+type Mount struct {
+	Volume                 string `json:"volume"`
+	Path                   string `json:"path"`
+	Name                   string `json:"name,omitempty"`
+	SizeGb                 int    `json:"size_gb,omitempty"`
+	Encrypted              bool   `json:"encrypted,omitempty"`
+	ExtendThresholdPercent int    `json:"extend_threshold_percent,omitempty"`
+	AddSizeGb              int    `json:"add_size_gb,omitempty"`
+	SizeGbLimit            int    `json:"size_gb_limit,omitempty"`
+}
+
+type DNS struct {
+	SkipRegistration bool             `json:"skip_registration,omitempty"`
+	Nameservers      []string         `json:"nameservers,omitempty"`
+	Searches         []string         `json:"searches,omitempty"`
+	Options          []DNSOption      `json:"options,omitempty"`
+	ForwardRules     []DNSForwardRule `json:"dns_forward_rules,omitempty"`
+	Hostname         string           `json:"hostname,omitempty"`
+	HostnameFqdn     string           `json:"hostname_fqdn,omitempty"`
+}
+
+type DNSOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+type DNSForwardRule struct {
+	Basename string `json:"basename"`
+	Addr     string `json:"addr"`
+}
+
+// Process runs in the machine alongside init, overriding its image's settings.
+type Process struct {
+	Exec             []string          `json:"exec,omitempty"`
+	Entrypoint       []string          `json:"entrypoint,omitempty"`
+	Cmd              []string          `json:"cmd,omitempty"`
+	Env              map[string]string `json:"env,omitempty"`
+	User             string            `json:"user,omitempty"`
+	IgnoreAppSecrets bool              `json:"ignore_app_secrets,omitempty"`
+	Secrets          []ProcessSecret   `json:"secrets,omitempty"`
+	EnvFrom          []ProcessEnvFrom  `json:"env_from,omitempty"`
+}
+
+// ProcessSecret sets EnvVar to the app secret Name.
+type ProcessSecret struct {
+	EnvVar string `json:"env_var"`
+	Name   string `json:"name"`
+}
+
+// ProcessEnvFrom sets EnvVar to a field of the machine, such as "machine_id" or "region".
+type ProcessEnvFrom struct {
+	EnvVar   string `json:"env_var"`
+	FieldRef string `json:"field_ref"`
+}
+
+// StopConfig is how the machine is stopped: Signal is sent to it,
+// and it is killed if it hasn't exited after Timeout.
+type StopConfig struct {
+	Timeout Duration `json:"timeout,omitempty"`
+	Signal  string   `json:"signal,omitempty"`
+}
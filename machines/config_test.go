@@ -0,0 +1,82 @@
+package machines
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+const fullConfig = `{
+  "init": {"exec": ["/bin/sleep", "inf"], "entrypoint": ["/init"], "cmd": ["serve"], "tty": true, "swap_size_mb": 512, "kernel_args": ["quiet"]},
+  "env": {"LOG_LEVEL": "debug"},
+  "metadata": {"pool_id": "p1//img"},
+  "services": [{
+    "protocol": "tcp",
+    "internal_port": 8001,
+    "autostop": "suspend",
+    "autostart": true,
+    "min_machines_running": 1,
+    "ports": [
+      {"port": 443, "handlers": ["tls", "http"], "force_https": true,
+       "tls_options": {"alpn": ["h2", "http/1.1"], "versions": ["TLSv1.3"], "default_self_signed": true},
+       "http_options": {"compress": true, "h2_backend": true, "idle_timeout": 60, "headers_read_timeout": 10,
+                        "response": {"headers": {"X-Worker": "bfaas", "Server": false}, "pristine": true}}},
+      {"start_port": 9000, "end_port": 9010, "proxy_proto_options": {"version": "v2"}}
+    ],
+    "checks": [{"type": "http", "port": 8001, "interval": "15s", "timeout": "2s", "grace_period": "1m0s",
+                "method": "GET", "path": "/health", "protocol": "https", "tls_skip_verify": true,
+                "tls_server_name": "worker", "headers": [{"name": "Host", "values": ["worker"]}]}],
+    "concurrency": {"type": "requests", "hard_limit": 1, "soft_limit": 1},
+    "force_instance_key": "m1",
+    "force_instance_description": "debugging"
+  }],
+  "checks": {"alive": {"type": "tcp", "port": 8001, "interval": "30s"}},
+  "image": "registry-1.docker.io/library/ubuntu:latest",
+  "auto_destroy": true,
+  "restart": {"policy": "on-failure", "max_retries": 3},
+  "guest": {"cpu_kind": "shared", "cpus": 1, "memory_mb": 256},
+  "files": [{"guest_path": "/etc/motd", "raw_value": "aGk=", "mode": 420}, {"guest_path": "/etc/key", "secret_name": "KEY"}],
+  "mounts": [{"volume": "vol_1", "path": "/data", "name": "data", "size_gb": 1, "encrypted": true,
+              "extend_threshold_percent": 80, "add_size_gb": 1, "size_gb_limit": 10}],
+  "dns": {"skip_registration": true, "nameservers": ["1.1.1.1"], "searches": ["internal"],
+          "options": [{"name": "ndots", "value": "2"}], "dns_forward_rules": [{"basename": "corp", "addr": "10.0.0.1"}],
+          "hostname": "worker", "hostname_fqdn": "worker.internal"},
+  "processes": [{"exec": ["/bin/true"], "entrypoint": ["/bin/sh"], "cmd": ["-c"], "env": {"A": "1"}, "user": "nobody",
+                 "ignore_app_secrets": true, "secrets": [{"env_var": "KEY", "name": "KEY"}],
+                 "env_from": [{"env_var": "REGION", "field_ref": "region"}]}],
+  "stop_config": {"timeout": "5s", "signal": "SIGINT"},
+  "standbys": ["m2"]
+}`
+
+func TestMachineConfigJSON(t *testing.T) {
+	var c MachineConfig
+	assert.NoError(t, json.Unmarshal([]byte(fullConfig), &c))
+	assert.Equal(t, AutostopSuspend, c.Services[0].Autostop)
+	assert.Equal(t, Duration(time.Minute), c.Services[0].Checks[0].GracePeriod)
+	assert.Equal(t, Port{StartPort: 9000, EndPort: 9010, ProxyProtoOptions: &ProxyProtoOptions{Version: "v2"}}, c.Services[0].Ports[1])
+	assert.Equal(t, &StopConfig{Timeout: Duration(5 * time.Second), Signal: "SIGINT"}, c.StopConfig)
+
+	bs, err := json.Marshal(&c)
+	assert.NoError(t, err)
+	var want, got any
+	assert.NoError(t, json.Unmarshal([]byte(fullConfig), &want))
+	assert.NoError(t, json.Unmarshal(bs, &got))
+	assert.Equal(t, want, got)
+}
+
+func TestAutostopJSON(t *testing.T) {
+	for in, want := range map[string]Autostop{`true`: AutostopStop, `false`: AutostopOff, `"stop"`: AutostopStop, `"off"`: AutostopOff} {
+		var s Service
+		assert.NoError(t, json.Unmarshal([]byte(`{"autostop": `+in+`}`), &s))
+		assert.Equal(t, want, s.Autostop, "autostop %s", in)
+	}
+
+	var s Service
+	assert.EqualError(t, json.Unmarshal([]byte(`{"autostop": 1}`), &s), "autostop: want bool or string, got 1")
+
+	bs, err := json.Marshal(Service{Protocol: "tcp", Autostop: AutostopOff})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"protocol":"tcp","internal_port":0,"autostop":"off","autostart":false,"min_machines_running":0}`, string(bs))
+}
@@ -2,17 +2,61 @@ package machines
 
 import (
 	"context"
+	"net/http"
+	"strings"
 
 	"github.com/superfly/coordBfaas/japi"
 )
 
+// ListRegion lists only machines in region.
+func ListRegion(region string) ReqOpt {
+	return japi.ReqQuery("region", region)
+}
+
+// ListMetadata lists only machines whose metadata has key set to value.
+// It can be given more than once to match several keys.
+func ListMetadata(key, value string) ReqOpt {
+	return japi.ReqQuery("metadata."+key, value)
+}
+
+// ListState lists only machines in one of states, such as "started" or "stopped".
+func ListState(states ...string) ReqOpt {
+	return japi.ReqQuery("state", strings.Join(states, ","))
+}
+
+// ListIncludeDeleted also lists machines that have been destroyed.
+func ListIncludeDeleted() ReqOpt {
+	return japi.ReqQuery("include_deleted", "true")
+}
+
+// ListSummary lists a summary of each machine, which makes the response smaller.
+// Fields left out of the summary are zero, so don't use it when the config is needed.
+func ListSummary() ReqOpt {
+	return japi.ReqQuery("summary", "true")
+}
+
+// List returns the machines in appName, filtered by any List options.
+// If a response links to a next page, List follows the links and returns the machines from every page.
 func (p *Api) List(ctx context.Context, appName string, opts ...ReqOpt) ([]MachineResp, error) {
-	// TODO: do we want to support include_deleted, region, metadata.key query params?
-	var resp []MachineResp
-	r := p.json.Req("GET", japi.ReqPath("/v1/apps/%s/machines", appName), japi.ReqRespBody(&resp))
-	r.ApplyOpts(opts...)
-	if err := r.Do(ctx); err != nil {
-		return nil, err
+	var machs []MachineResp
+	next := ""
+	for {
+		var resp []MachineResp
+		var header http.Header
+		r := p.json.Req("GET", japi.ReqPath("/v1/apps/%s/machines", appName), japi.ReqRespBody(&resp), japi.ReqRespHeader(&header))
+		r.ApplyOpts(opts...)
+		if next != "" {
+			r.ApplyOpts(japi.ReqLink(next))
+		}
+		if err := r.Do(ctx); err != nil {
+			return nil, err
+		}
+		machs = append(machs, resp...)
+
+		link := japi.NextLink(header)
+		if link == "" || link == next {
+			return machs, nil
+		}
+		next = link
 	}
-	return resp, nil
 }
@@ -0,0 +1,47 @@
+package machines
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestListOpts(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		json.NewEncoder(w).Encode([]MachineResp{{Id: "m1", State: "stopped"}})
+	}))
+	defer srv.Close()
+
+	api := New("token", srv.URL)
+	machs, err := api.List(context.Background(), "app",
+		ListRegion("qmx"), ListMetadata("pool_id", "p1//img:latest"), ListState("started", "stopped"), ListIncludeDeleted(), ListSummary())
+	assert.NoError(t, err)
+	assert.Equal(t, []MachineResp{{Id: "m1", State: "stopped"}}, machs)
+	assert.Equal(t, "include_deleted=true&metadata.pool_id=p1%2F%2Fimg%3Alatest&region=qmx&state=started%2Cstopped&summary=true", query)
+}
+
+func TestListPages(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		if r.URL.Query().Get("cursor") == "" {
+			w.Header().Set("Link", `<`+r.URL.Path+`?cursor=c2&region=qmx>; rel="next"`)
+			json.NewEncoder(w).Encode([]MachineResp{{Id: "m1"}, {Id: "m2"}})
+			return
+		}
+		json.NewEncoder(w).Encode([]MachineResp{{Id: "m3"}})
+	}))
+	defer srv.Close()
+
+	api := New("token", srv.URL)
+	machs, err := api.List(context.Background(), "app", ListRegion("qmx"))
+	assert.NoError(t, err)
+	assert.Equal(t, []MachineResp{{Id: "m1"}, {Id: "m2"}, {Id: "m3"}}, machs)
+	assert.Equal(t, []string{"region=qmx", "cursor=c2&region=qmx"}, queries)
+}
@@ -0,0 +1,222 @@
+package pool
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/superfly/coordBfaas/stats"
+)
+
+// Autoscaler actions, as recorded in the decisions counter.
+const (
+	scaleUp   = "up"
+	scaleDown = "down"
+	scaleHold = "hold"
+	scaleTrim = "trim"
+	scaleWarm = "warm"
+)
+
+// AutoscaleConfig configures a pool's autoscaler. See Autoscale.
+type AutoscaleConfig struct {
+	// Min and Max bound the pool capacity.
+	Min int
+	Max int
+
+	// Interval is how often the autoscaler decides, and Window is how far back it looks.
+	Interval time.Duration
+	Window   time.Duration
+
+	// The pool grows when utilization is at least UpUtil, when the 90th percentile
+	// wait for a free machine is over MaxWait, or when allocations are waiting.
+	// It shrinks when utilization is at most DownUtil and capacity has not changed
+	// for DownDelay. Between DownUtil and UpUtil, capacity holds.
+	UpUtil    float64
+	DownUtil  float64
+	MaxWait   time.Duration
+	DownDelay time.Duration
+
+	// IdleFraction of capacity, but at least MinIdle machines, are kept warm as
+	// free machines, creating them ahead of demand when there are fewer.
+	// Free machines above that are destroyed once capacity has not changed
+	// for DownDelay, rather than sitting until their lease runs out.
+	IdleFraction float64
+	MinIdle      int
+}
+
+// DefaultAutoscale returns an autoscaler configuration for a pool of min to max machines.
+func DefaultAutoscale(min, max int) AutoscaleConfig {
+	return AutoscaleConfig{
+		Min:          min,
+		Max:          max,
+		Interval:     10 * time.Second,
+		Window:       time.Minute,
+		UpUtil:       0.8,
+		DownUtil:     0.4,
+		MaxWait:      100 * time.Millisecond,
+		DownDelay:    2 * time.Minute,
+		IdleFraction: 0.25,
+		MinIdle:      1,
+	}
+}
+
+// Autoscale enables an autoscaler that adjusts the pool capacity
+// and the number of warm free machines based on demand.
+// The pool starts with its configured capacity, clamped to cfg.Min and cfg.Max.
+func Autoscale(cfg AutoscaleConfig) Opt {
+	return func(p *FlyPool) { p.autoscaleCfg = &cfg }
+}
+
+// scaleInput is what the autoscaler knows about recent demand.
+type scaleInput struct {
+	capacity    int
+	waiting     int
+	util        float64 // mean utilization over the window, or NaN if unknown
+	waitP90     time.Duration
+	sinceChange time.Duration
+}
+
+// scaleDecision is what the autoscaler decided to do.
+type scaleDecision struct {
+	action   string
+	capacity int
+	warmIdle int
+}
+
+// decide picks a new capacity and warm idle target from in.
+// It grows the pool by the number of waiting allocations, or by one,
+// and shrinks it by one at a time.
+func (cfg *AutoscaleConfig) decide(in scaleInput) scaleDecision {
+	capacity := min(max(in.capacity, cfg.Min), cfg.Max)
+	switch {
+	case in.waiting > 0 || in.waitP90 > cfg.MaxWait || in.util >= cfg.UpUtil:
+		capacity = min(cfg.Max, capacity+max(1, in.waiting))
+	case in.sinceChange >= cfg.DownDelay && in.util <= cfg.DownUtil:
+		capacity = max(cfg.Min, capacity-1)
+	}
+
+	d := scaleDecision{action: scaleHold, capacity: capacity}
+	if capacity > in.capacity {
+		d.action = scaleUp
+	} else if capacity < in.capacity {
+		d.action = scaleDown
+	}
+
+	warm := int(math.Ceil(cfg.IdleFraction * float64(capacity)))
+	d.warmIdle = min(max(warm, cfg.MinIdle), capacity)
+	return d
+}
+
+// autoscaler adjusts a pool's capacity from its recent demand.
+type autoscaler struct {
+	cfg  AutoscaleConfig
+	pool *FlyPool
+
+	waits      *stats.Window
+	util       *stats.Window
+	lastChange time.Time
+
+	utilGauge *stats.Gauge
+	warmGauge *stats.Gauge
+	decisions func(action string) *stats.Counter
+}
+
+func newAutoscaler(p *FlyPool, cfg AutoscaleConfig, reg *stats.Registry, labels []string) *autoscaler {
+	return &autoscaler{
+		cfg:        cfg,
+		pool:       p,
+		waits:      stats.NewWindow(cfg.Window),
+		util:       stats.NewWindow(cfg.Window),
+		lastChange: p.now(),
+
+		utilGauge: reg.Gauge("bfaas_pool_autoscale_utilization", "Mean fraction of capacity allocated over the autoscale window", labels...),
+		warmGauge: reg.Gauge("bfaas_pool_autoscale_warm_idle", "Number of free machines the autoscaler keeps warm", labels...),
+		decisions: func(action string) *stats.Counter {
+			return reg.Counter("bfaas_pool_autoscale_decisions_total", "Autoscaler decisions by action",
+				append(slices.Clone(labels), "action", action)...)
+		},
+	}
+}
+
+// sample records the current utilization.
+func (a *autoscaler) sample() {
+	m := a.pool.Metrics()
+	a.util.Add(float64(m.Size-m.Free) / float64(m.Capacity))
+}
+
+// step makes and applies one autoscaling decision, and then creates or
+// destroys free machines to meet the warm idle target.
+func (a *autoscaler) step(ctx context.Context) {
+	var waitP90 time.Duration
+	if q := a.waits.Snapshot().Quantile(0.9); !math.IsNaN(q) {
+		waitP90 = time.Duration(q * float64(time.Second))
+	}
+
+	m := a.pool.Metrics()
+	in := scaleInput{
+		capacity:    m.Capacity,
+		waiting:     int(a.pool.waiting.Load()),
+		util:        a.util.Stats().Avg,
+		waitP90:     waitP90,
+		sinceChange: a.pool.now().Sub(a.lastChange),
+	}
+	d := a.cfg.decide(in)
+
+	a.utilGauge.Set(in.util)
+	a.warmGauge.Set(float64(d.warmIdle))
+	a.decisions(d.action).Inc()
+
+	changed := d.capacity != in.capacity
+	if changed {
+		slog.Info("pool: autoscale", "action", d.action, "capacity", d.capacity, "util", in.util,
+			"waiting", in.waiting, "wait_p90", in.waitP90)
+		a.pool.setCapacity(d.capacity)
+		a.lastChange = a.pool.now()
+	}
+
+	free := a.pool.free.len()
+	if free < d.warmIdle {
+		if n := a.pool.growFree(ctx, d.warmIdle-free); n > 0 {
+			slog.Info("pool: autoscale: warmed machines", "count", n, "warm_idle", d.warmIdle)
+			a.decisions(scaleWarm).Add(int64(n))
+		}
+		return
+	}
+
+	// Let idle machines go once demand has settled.
+	if changed || in.sinceChange < a.cfg.DownDelay {
+		return
+	}
+	for n := free; n > d.warmIdle; n-- {
+		mach := a.pool.free.tryPop()
+		if mach == nil {
+			break
+		}
+		a.decisions(scaleTrim).Inc()
+		a.pool.discardMach(mach, "idle above warm target")
+	}
+}
+
+// run samples utilization every second and steps every cfg.Interval until ctx is done.
+func (a *autoscaler) run(ctx context.Context) {
+	slog.Debug("pool: autoscale: starting")
+	sample := time.NewTicker(time.Second)
+	defer sample.Stop()
+	step := time.NewTicker(a.cfg.Interval)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Debug("pool: autoscale: exiting")
+			a.pool.wg.Done()
+			return
+		case <-sample.C:
+			a.sample()
+		case <-step.C:
+			a.step(ctx)
+		}
+	}
+}
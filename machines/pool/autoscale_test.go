@@ -0,0 +1,106 @@
+package pool
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/superfly/coordBfaas/stats"
+)
+
+func TestAutoscaleDecide(t *testing.T) {
+	cfg := DefaultAutoscale(2, 10)
+	settled := cfg.DownDelay
+
+	cases := []struct {
+		name string
+		in   scaleInput
+		want scaleDecision
+	}{
+		{"no data holds",
+			scaleInput{capacity: 4, util: math.NaN(), sinceChange: settled},
+			scaleDecision{scaleHold, 4, 1}},
+		{"busy grows",
+			scaleInput{capacity: 4, util: 0.9},
+			scaleDecision{scaleUp, 5, 2}},
+		{"waiting grows by waiters",
+			scaleInput{capacity: 4, util: 0.5, waiting: 3},
+			scaleDecision{scaleUp, 7, 2}},
+		{"slow waits grow",
+			scaleInput{capacity: 4, util: 0.5, waitP90: time.Second},
+			scaleDecision{scaleUp, 5, 2}},
+		{"growth stops at max",
+			scaleInput{capacity: 9, util: 1, waiting: 5},
+			scaleDecision{scaleUp, 10, 3}},
+		{"between thresholds holds",
+			scaleInput{capacity: 4, util: 0.6, sinceChange: settled},
+			scaleDecision{scaleHold, 4, 1}},
+		{"idle shrinks once settled",
+			scaleInput{capacity: 4, util: 0.1, sinceChange: settled},
+			scaleDecision{scaleDown, 3, 1}},
+		{"idle holds until settled",
+			scaleInput{capacity: 4, util: 0.1, sinceChange: settled - time.Second},
+			scaleDecision{scaleHold, 4, 1}},
+		{"shrinking stops at min",
+			scaleInput{capacity: 2, util: 0, sinceChange: settled},
+			scaleDecision{scaleHold, 2, 1}},
+		{"out of range is clamped",
+			scaleInput{capacity: 20, util: 0.6},
+			scaleDecision{scaleDown, 10, 3}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, cfg.decide(c.in))
+		})
+	}
+}
+
+func TestAutoscaleStep(t *testing.T) {
+	p, f := newTestPool(t, 4)
+	cfg := DefaultAutoscale(2, 10)
+	a := newAutoscaler(p, cfg, stats.NewRegistry(), nil)
+	p.autoscaler = a
+	ctx := context.Background()
+
+	// Manual changes would be undone by the autoscaler, so they are refused.
+	assert.IsError(t, p.SetCapacity(8), ErrAutoscaled)
+	assert.Equal(t, 4, p.capacity)
+
+	// With no demand yet, capacity holds and a stopped machine is warmed.
+	a.step(ctx)
+	assert.Equal(t, 4, p.capacity)
+	assert.Equal(t, 1, p.free.len())
+	assert.Equal(t, int64(1), f.created.Load())
+	mach := p.free.tryPop()
+	assert.Equal(t, "stopped", mach.getState())
+	p.free.push(mach)
+
+	// Idle machines shrink the pool once settled, and then free machines are trimmed to the warm target.
+	p.growFree(ctx, 3)
+	a.util.Add(0)
+	settle := func() { a.lastChange = p.now().Add(-cfg.DownDelay) }
+	settle()
+	a.step(ctx)
+	assert.Equal(t, 3, p.capacity)
+	assert.Equal(t, 3, p.free.len())
+
+	settle()
+	a.step(ctx)
+	assert.Equal(t, 2, p.capacity)
+	assert.Equal(t, 2, p.free.len())
+
+	settle()
+	a.step(ctx)
+	assert.Equal(t, 2, p.capacity)
+	assert.Equal(t, 1, p.free.len())
+
+	// When the warm machine is allocated, another is created to replace it.
+	p.free.tryPop()
+	a.step(ctx)
+	assert.Equal(t, 1, p.free.len())
+	assert.Equal(t, int64(5), f.created.Load())
+}
@@ -0,0 +1,79 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/superfly/coordBfaas/machines"
+	"github.com/superfly/coordBfaas/stats"
+)
+
+// fakeApi serves enough of the machines API for pool tests.
+// Every call succeeds, except creates while failCreate is set.
+type fakeApi struct {
+	created    atomic.Int64
+	leases     atomic.Int64
+	failCreate atomic.Bool
+}
+
+func newFakeApi(t *testing.T) (*fakeApi, *machines.Api) {
+	f := &fakeApi{}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	return f, machines.New("token", srv.URL)
+}
+
+func (f *fakeApi) serve(w http.ResponseWriter, r *http.Request) {
+	var resp any = machines.OkResp{Ok: true}
+	switch {
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/machines") && f.failCreate.Load():
+		http.Error(w, `{"error": "no capacity"}`, http.StatusInternalServerError)
+		return
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/machines"):
+		var req machines.CreateMachineReq
+		json.NewDecoder(r.Body).Decode(&req)
+		n := f.created.Add(1)
+		resp = machines.MachineResp{Id: fmt.Sprintf("m%d", n), Name: req.Name, InstanceId: "i1", State: "started", Nonce: "nonce"}
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/machines"):
+		resp = []machines.MachineResp{}
+	case strings.HasSuffix(r.URL.Path, "/lease"):
+		f.leases.Add(1)
+		resp = machines.LeaseResp{Status: "success", Data: machines.LeaseData{Nonce: "nonce"}}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newTestPool returns a pool of capacity machines backed by a fakeApi,
+// without its background goroutines.
+func newTestPool(t *testing.T, capacity int) (*FlyPool, *fakeApi) {
+	f, api := newFakeApi(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p := &FlyPool{
+		api:        api,
+		name:       "m8001",
+		appName:    "app",
+		metadata:   "m8001//image",
+		machRegion: "qmx",
+		machGuest:  &defaultGuest,
+		capacity:   capacity,
+		leaseTime:  time.Hour,
+		workerTime: time.Minute,
+		now:        time.Now,
+		machs:      make(map[string]*Mach),
+		free:       newMachQueue(),
+		discards:   newMachQueue(),
+		stateDirty: make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	p.register(stats.NewRegistry())
+	return p, f
+}
@@ -4,30 +4,32 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/superfly/coordBfaas/japi"
+	"golang.org/x/time/rate"
+
 	"github.com/superfly/coordBfaas/machines"
 	"github.com/superfly/coordBfaas/stats"
+	"github.com/superfly/coordBfaas/tracing"
 )
 
 const MetaPoolKey = "pool_id"
 
-const (
-	statsAlloc   = "alloc"
-	statsCreate  = "create"
-	statsStart   = "start"
-	statsStop    = "stop"
-	statsDestroy = "destroy"
-	statsLease   = "lease"
-)
-
 var cleanerDelay = 5 * time.Minute
+
+// Every fullCleanEvery cleaning passes, starting with the first, list all machines in the region
+// rather than only those with the pool's metadata, to find machines from other pools
+// and machines whose metadata was changed.
+var fullCleanEvery = 6
 var ErrPoolClosed = fmt.Errorf("The Pool Is Closed")
+var ErrNoMachine = fmt.Errorf("no such machine in pool")
+var ErrAutoscaled = fmt.Errorf("capacity is set by the autoscaler")
 var defaultGuest = machines.Guest{
 	CpuKind:  "shared",
 	Cpus:     1,
@@ -55,15 +57,18 @@ func newWorkerName(poolName string) string {
 	return fmt.Sprintf("worker-%s-%d", poolName, rand.Uint64())
 }
 
-func parseWorkerName(name string) (string, error) {
-	ws := strings.Split(name, "-")
-	if len(ws) != 3 {
-		return "", fmt.Errorf("malformed worker name format")
+// parseWorkerName returns the pool name and the unique suffix from a name
+// made by newWorkerName or signedWorkerName. Pool names may contain dashes.
+func parseWorkerName(name string) (poolName, suffix string, err error) {
+	rest, ok := strings.CutPrefix(name, "worker-")
+	if !ok {
+		return "", "", fmt.Errorf("malformed worker name tag")
 	}
-	if ws[0] != "worker" {
-		return "", fmt.Errorf("malformed worker name tag")
+	i := strings.LastIndex(rest, "-")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", fmt.Errorf("malformed worker name format")
 	}
-	return ws[1], nil
+	return rest[:i], rest[i+1:], nil
 }
 
 // FlyPool is a pool of Fly machines.
@@ -73,11 +78,13 @@ type FlyPool struct {
 	capacity   int
 	leaseTime  time.Duration
 	workerTime time.Duration
+	readyTime  time.Duration
 
 	appName    string
 	machImage  string
 	machPort   int
 	machGuest  *machines.Guest
+	machEnv    map[string]string
 	machRegion string
 
 	now func() time.Time // TODO: for mocking. do we really need this?
@@ -88,16 +95,41 @@ type FlyPool struct {
 	// have been returned to the pool and no further operations will
 	// be performed.
 	isShutdown bool
+	ctx        context.Context // done when the pool shuts down
 	cancel     context.CancelFunc
 	wg         sync.WaitGroup
 	freeWg     sync.WaitGroup
 
+	// cleanMu serializes cleaning passes, and guards cleanPasses.
+	cleanMu     sync.Mutex
+	cleanPasses int
+
 	mu       sync.Mutex
 	machs    map[string]*Mach
-	free     chan *Mach
-	discards chan *Mach
-
-	stats map[string]*stats.Collector
+	free     *machQueue
+	discards *machQueue
+	cleanNow chan struct{}
+
+	reg    *stats.Registry
+	labels []string
+	stats  poolStats
+
+	// ownerKey signs worker names, if set. See OwnerKey.
+	ownerKey []byte
+
+	// stateDir holds the state file, if set. See StateDir.
+	stateDir       string
+	stateDirty     chan struct{}
+	stateReconcile bool
+
+	// Leases of free machines are renewed when they have less than renewBefore left.
+	renewBefore time.Duration
+	renewLimit  *rate.Limiter
+
+	// waiting is the number of allocations waiting for a free machine.
+	waiting      atomic.Int64
+	autoscaleCfg *AutoscaleConfig
+	autoscaler   *autoscaler
 }
 
 var _ Pool = (*FlyPool)(nil)
@@ -119,6 +151,11 @@ func LeaseTime(d time.Duration) Opt {
 	return func(p *FlyPool) { p.leaseTime = d }
 }
 
+// ReadyTime sets how long to wait for a started worker to report ready.
+func ReadyTime(d time.Duration) Opt {
+	return func(p *FlyPool) { p.readyTime = d }
+}
+
 func Port(port int) Opt {
 	return func(p *FlyPool) { p.machPort = port }
 }
@@ -127,10 +164,26 @@ func Guest(guest *machines.Guest) Opt {
 	return func(p *FlyPool) { p.machGuest = guest }
 }
 
+// Env sets environment variables in the worker machines.
+func Env(env map[string]string) Opt {
+	return func(p *FlyPool) { p.machEnv = env }
+}
+
 func Region(region string) Opt {
 	return func(p *FlyPool) { p.machRegion = region }
 }
 
+// Registry sets the registry the pool registers its statistics in.
+func Registry(reg *stats.Registry) Opt {
+	return func(p *FlyPool) { p.reg = reg }
+}
+
+// Labels adds label names and values to the pool's statistics,
+// to tell apart pools that share a registry and region.
+func Labels(kv ...string) Opt {
+	return func(p *FlyPool) { p.labels = append(p.labels, kv...) }
+}
+
 // New creates a new machine pool of up to capacity machines owned by this pool.
 // Name should be a unique name for the pool, such as the pool machine name.
 func New(api *machines.Api, poolName, appName, image string, opts ...Opt) (*FlyPool, error) {
@@ -140,6 +193,7 @@ func New(api *machines.Api, poolName, appName, image string, opts ...Opt) (*FlyP
 		capacity:   2,
 		leaseTime:  30 * time.Minute,
 		workerTime: time.Minute,
+		readyTime:  10 * time.Second,
 
 		appName:   appName,
 		machImage: image,
@@ -153,31 +207,53 @@ func New(api *machines.Api, poolName, appName, image string, opts ...Opt) (*FlyP
 
 		machs: make(map[string]*Mach),
 
-		stats: map[string]*stats.Collector{
-			statsAlloc:   stats.New(),
-			statsCreate:  stats.New(),
-			statsStart:   stats.New(),
-			statsStop:    stats.New(),
-			statsDestroy: stats.New(),
-			statsLease:   stats.New(),
-		},
+		reg: stats.NewRegistry(),
 	}
 
 	for _, opt := range opts {
 		opt(p)
 	}
 
-	// construct after p.capacity might be set by options.
-	p.free = make(chan *Mach, p.capacity)
-	p.discards = make(chan *Mach, p.capacity)
+	if cfg := p.autoscaleCfg; cfg != nil {
+		p.capacity = min(max(p.capacity, cfg.Min), cfg.Max)
+	}
+	if p.renewBefore == 0 {
+		p.renewBefore = p.leaseTime / 2
+	}
+	if p.renewLimit == nil {
+		p.renewLimit = rate.NewLimiter(DefaultRenewRate, 1)
+	}
+
+	// register after p.machRegion might be set by options.
+	p.register(p.reg)
+
+	p.free = newMachQueue()
+	p.discards = newMachQueue()
+	p.cleanNow = make(chan struct{}, 1)
+	p.stateDirty = make(chan struct{}, 1)
+	if p.stateDir != "" {
+		p.loadState()
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	p.cancel = cancel
+	p.ctx, p.cancel = ctx, cancel
+	if p.stateDir != "" {
+		p.wg.Add(1)
+		go p.writeState(ctx)
+	}
 
 	// claim orphans for our pool and cleanup, in the background.
 	p.wg.Add(2)
 	go p.handleDiscards(ctx)
 	go p.clean(ctx)
+	if p.renewBefore > 0 {
+		p.wg.Add(1)
+		go p.renewLeases(ctx)
+	}
+	if p.autoscaler != nil {
+		p.wg.Add(1)
+		go p.autoscaler.run(ctx)
+	}
 
 	return p, nil
 }
@@ -185,22 +261,24 @@ func New(api *machines.Api, poolName, appName, image string, opts ...Opt) (*FlyP
 // shutdown stops the pool but does not perform cleanup.
 // See Close/Destory for cleanup
 func (p *FlyPool) shutdown() {
-	log.Printf("pool: shutdown")
+	slog.Info("pool: shutdown")
 
 	// make sure bg freeMach threads are finished.
 	p.freeWg.Wait()
 
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	if p.isShutdown {
+		p.mu.Unlock()
 		return
 	}
 
 	p.isShutdown = true
-	close(p.free)
-	close(p.discards)
+	p.free.close()
+	p.discards.close()
 	p.cancel()
+	p.mu.Unlock()
+
+	// Background tasks may need the lock to finish.
 	p.wg.Wait()
 }
 
@@ -242,35 +320,43 @@ func (p *FlyPool) Destroy() error {
 		err = errors.Join(err, mach.destroy(bgctx))
 		delete(p.machs, mach.Name)
 	}
+	p.saveStateLocked()
 	return err
 }
 
 // addFreeMach adds the mach to the pool as a free machine if it is needed.
 // The machine should be "stopped" and should not yet be in the pool.
+// A machine that is already in the pool is left as it is.
 func (p *FlyPool) addFreeMach(mach *Mach) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.machs[mach.Name] != nil {
+		slog.Warn("pool: add: already in pool", "name", mach.Name, "mach", mach.Id)
+		return true
+	}
 	if len(p.machs) < p.capacity {
 		p.machs[mach.Name] = mach
-		p.free <- mach
+		p.free.push(mach)
+		p.stateChanged()
 		return true
 	}
 	return false
 }
 
 // createMach creates a new machine and starts it.
-func (p *FlyPool) createMach(ctx context.Context, mach *Mach) error {
-	dt := p.stats[statsCreate].Start()
-	defer dt.End()
+func (p *FlyPool) createMach(ctx context.Context, mach *Mach) (err error) {
+	ctx, done := track(ctx, "create", p.stats.create)
+	defer func() { done(err) }()
 
 	req := machines.CreateMachineReq{
 		Name:       mach.Name,
-		LeaseTTL:   int(mach.leaseExpires.Sub(time.Now()).Seconds()),
+		LeaseTTL:   int(mach.expires().Sub(time.Now()).Seconds()),
 		SkipLaunch: false,
 		Region:     p.machRegion,
 		Config: machines.MachineConfig{
 			Image: p.machImage,
+			Env:   p.machEnv,
 			Guest: *p.machGuest,
 			Restart: machines.Restart{
 				Policy: "no",
@@ -282,7 +368,7 @@ func (p *FlyPool) createMach(ctx context.Context, mach *Mach) error {
 				machines.Service{
 					Protocol:     "tcp",
 					InternalPort: p.machPort,
-					Autostop:     false,
+					Autostop:     machines.AutostopOff,
 					Autostart:    false,
 					Ports: []machines.Port{
 						machines.Port{
@@ -296,17 +382,19 @@ func (p *FlyPool) createMach(ctx context.Context, mach *Mach) error {
 		},
 	}
 
-	log.Printf("pool: create %s %s", p.appName, req.Name)
+	slog.InfoContext(ctx, "pool: create", "app", p.appName, "name", req.Name)
 	flym, err := p.api.Create(ctx, p.appName, &req)
 	if err != nil {
 		return fmt.Errorf("api.Create %s: %w", p.appName, err)
 	}
 
+	mach.mu.Lock()
 	mach.Id = flym.Id
 	mach.InstanceId = flym.InstanceId
 	mach.leaseNonce = flym.Nonce
+	mach.mu.Unlock()
 
-	//log.Printf("pool: create %s %s: success %v", p.appName, req.Name, mach.Id)
+	//slog.DebugContext(ctx, "pool: create: success", "app", p.appName, "name", req.Name, "mach", mach.Id)
 	if err := mach.waitFor(ctx, "started"); err != nil {
 		return fmt.Errorf("api.Create %s: %w", p.appName, err)
 	}
@@ -319,12 +407,12 @@ func (p *FlyPool) createMach(ctx context.Context, mach *Mach) error {
 // does not add it to the free list.
 func (p *FlyPool) growPool(ctx context.Context) (*Mach, error) {
 	var nascent *Mach
-	defer p.discardMach(nascent, "growPool failed")
+	defer func() { p.discardMach(nascent, "growPool failed") }()
 
 	// Allocate the nascent machine under lock.
 	p.mu.Lock()
 	if len(p.machs) < p.capacity {
-		name := newWorkerName(p.name)
+		name := p.newWorkerName()
 		expire := p.now().Add(p.leaseTime)
 		nascent = newMachNascent(p, name, expire)
 		p.machs[nascent.Name] = nascent
@@ -332,29 +420,50 @@ func (p *FlyPool) growPool(ctx context.Context) (*Mach, error) {
 	p.mu.Unlock()
 
 	if nascent == nil {
-		log.Printf("pool: growPool: cant grow")
+		slog.DebugContext(ctx, "pool: growPool: cant grow")
 		return nil, nil
 	}
 
 	// Bring the nascent machine up, or discard it.
 	if err := p.createMach(ctx, nascent); err != nil {
-		log.Printf("pool: growPool: createMach failed: %v", err)
+		slog.ErrorContext(ctx, "pool: growPool: createMach failed", "err", err)
 		return nil, err
 	}
 
 	mach := nascent
 	nascent = nil
+	p.stateChanged()
 	return mach, nil
 }
 
+// growFree creates up to n machines while the pool has room, and adds them to the free list.
+// New machines are stopped first, unless allocations are waiting for them.
+// It returns the number of machines added.
+func (p *FlyPool) growFree(ctx context.Context, n int) int {
+	added := 0
+	for ; added < n; added++ {
+		mach, err := p.growPool(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "pool: growFree", "err", err)
+			break
+		}
+		if mach == nil {
+			break
+		}
+		if p.waiting.Load() == 0 {
+			if err := mach.stop(ctx); err != nil {
+				p.discardMach(mach, fmt.Sprintf("stop new machine failed: %v", err))
+				break
+			}
+		}
+		p.free.push(mach)
+	}
+	return added
+}
+
 // getFreeImmediately returns the next free machine if there are any immediately available.
 func (p *FlyPool) getFreeImmediately() *Mach {
-	select {
-	case mach := <-p.free:
-		return mach
-	default:
-		return nil
-	}
+	return p.free.tryPop()
 }
 
 // waitForFree returns the next free machine, waiting for one if none is available.
@@ -363,16 +472,25 @@ func (p *FlyPool) waitForFree(ctx context.Context) (*Mach, error) {
 		return nil, ErrPoolClosed
 	}
 
-	select {
-	case <-ctx.Done():
-		log.Printf("pool: alloc: cancelled")
-		return nil, ctx.Err()
-	case mach := <-p.free:
-		if mach == nil {
-			log.Printf("pool: alloc: cancelled: pool closed")
-			return nil, ErrPoolClosed
-		}
-		return mach, nil
+	p.waiting.Add(1)
+	mach, err := p.free.pop(ctx)
+	p.waiting.Add(-1)
+	if errors.Is(err, ErrPoolClosed) {
+		slog.InfoContext(ctx, "pool: alloc: cancelled: pool closed")
+		return nil, err
+	}
+	if err != nil {
+		slog.InfoContext(ctx, "pool: alloc: cancelled")
+		return nil, err
+	}
+	return mach, nil
+}
+
+// recordWait records how long an allocation waited for a free machine.
+func (p *FlyPool) recordWait(dt time.Duration) {
+	p.stats.wait.Add(dt.Seconds())
+	if p.autoscaler != nil {
+		p.autoscaler.waits.Add(dt.Seconds())
 	}
 }
 
@@ -386,6 +504,7 @@ func (p *FlyPool) allocLeased(ctx context.Context, waitForFree bool) (*Mach, err
 		mach, err := func() (*Mach, error) {
 			mach := p.getFreeImmediately()
 			if mach != nil {
+				p.recordWait(0)
 				return mach, nil
 			}
 
@@ -394,10 +513,13 @@ func (p *FlyPool) allocLeased(ctx context.Context, waitForFree bool) (*Mach, err
 				return nil, err
 			}
 			if mach != nil {
+				p.recordWait(0)
 				return mach, nil
 			}
 
 			if waitForFree {
+				start := time.Now()
+				defer func() { p.recordWait(time.Now().Sub(start)) }()
 				return p.waitForFree(ctx)
 			}
 
@@ -411,19 +533,24 @@ func (p *FlyPool) allocLeased(ctx context.Context, waitForFree bool) (*Mach, err
 			return nil, nil
 		}
 
+		if mach.retired.Load() {
+			p.discardMach(mach, "retired")
+			continue
+		}
+
 		if mach.leaseSufficient(p.workerTime) {
 			return mach, nil
 		}
 
 		// if the lease is still good, extend it.
-		if mach.leaseExpires.After(p.now()) {
+		if mach.expires().After(p.now()) {
 			expire := p.now().Add(p.leaseTime)
 			err = mach.updateLease(ctx, expire)
 			if err == nil {
 				return mach, nil
 			}
 
-			log.Printf("pool: alloc: extend lease failed: %v", err)
+			slog.WarnContext(ctx, "pool: alloc: extend lease failed", "err", err)
 		}
 
 		p.discardMach(mach, "not enough lease left")
@@ -432,9 +559,13 @@ func (p *FlyPool) allocLeased(ctx context.Context, waitForFree bool) (*Mach, err
 }
 
 // Alloc returns the next free machine, blocking if necessary.
-func (p *FlyPool) Alloc(ctx context.Context, waitForFree bool) (*Mach, error) {
-	dt := p.stats[statsAlloc].Start()
-	defer dt.End()
+// The machine is started and has reported ready before it is returned.
+// If the machine fails to become ready, it is discarded and an error
+// wrapping ErrWorkerBoot is returned.
+func (p *FlyPool) Alloc(ctx context.Context, waitForFree bool) (_ *Mach, err error) {
+	freeCtx := tracing.Detach(ctx)
+	ctx, done := track(ctx, "alloc", p.stats.alloc)
+	defer func() { done(err) }()
 
 	mach, err := p.allocLeased(ctx, waitForFree)
 	if err != nil || mach == nil {
@@ -442,15 +573,36 @@ func (p *FlyPool) Alloc(ctx context.Context, waitForFree bool) (*Mach, error) {
 	}
 
 	if err := mach.start(ctx); err != nil {
-		log.Printf("pool: mach.start: %v", err)
+		slog.ErrorContext(ctx, "pool: mach.start", "err", err)
 		p.discardMach(mach, "start machine failed")
 		return nil, err
 	}
 
-	log.Printf("pool: alloc %s %s %s", p.appName, mach.Name, mach.Id)
+	if err := mach.waitReady(ctx); err != nil {
+		slog.ErrorContext(ctx, "pool: mach.waitReady", "err", err)
+		p.discardMach(mach, "worker failed to boot")
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "pool: alloc", "app", p.appName, "name", mach.Name, "mach", mach.Id)
+	mach.released.Store(false)
+	mach.freeCtx = freeCtx
 	return mach, nil
 }
 
+// Owns returns true if machId is a machine in this pool.
+func (p *FlyPool) Owns(machId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, mach := range p.machs {
+		if mach.getId() == machId {
+			return true
+		}
+	}
+	return false
+}
+
 // freeMach stops a machine and returns it to the pool.
 // Freeing is done in a background context to stop machines as best as possible.
 // This can block for a few seconds, but is safe to call as `go p.Free(mach)`.
@@ -458,22 +610,32 @@ func (p *FlyPool) freeMach(mach *Mach) {
 	if p.isShutdown {
 		return
 	}
-	log.Printf("pool: free %s %s %s", p.appName, mach.Name, mach.Id)
+	slog.InfoContext(mach.freeCtx, "pool: free", "app", p.appName, "name", mach.Name, "mach", mach.Id)
+	if p.retire(mach) {
+		p.discardMach(mach, "retired")
+		return
+	}
 
 	// Don't make caller wait for the machine to stop,
 	p.freeWg.Add(1)
 	go func() {
 		defer p.freeWg.Done()
 
-		ctx := context.Background()
-		if err := mach.stop(ctx); err != nil {
-			log.Printf("pool free: stopMach %v %v: %v", mach.Name, mach.Id, err)
+		ctx, span := tracing.Start(mach.freeCtx, "pool", "pool.free")
+		err := mach.stop(ctx)
+		tracing.End(span, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "pool: free: stopMach", "name", mach.Name, "mach", mach.Id, "err", err)
 			p.discardMach(mach, "stop machine failed")
 			return
 		}
 
-		//log.Printf("pool free: stopMach %v %v: done", mach.Name, mach.Id)
-		p.free <- mach
+		//slog.DebugContext(ctx, "pool: free: stopMach: done", "name", mach.Name, "mach", mach.Id)
+		if p.retire(mach) {
+			p.discardMach(mach, "retired")
+			return
+		}
+		p.free.push(mach)
 	}()
 }
 
@@ -483,23 +645,46 @@ func (p *FlyPool) discardMach(mach *Mach, msg string) {
 		return
 	}
 
-	log.Printf("pool: discard machine %v %v: %v", mach.Name, mach.Id, msg)
+	slog.WarnContext(mach.freeCtx, "pool: discard machine", "name", mach.Name, "mach", mach.Id, "reason", msg)
+	p.stats.discards.Inc()
 
 	p.mu.Lock()
 	delete(p.machs, mach.Name)
 	p.mu.Unlock()
+	p.stateChanged()
 
-	p.discards <- mach
+	p.discards.push(mach)
+}
+
+// retire returns true if mach should be discarded rather than freed,
+// because it was retired or the pool is over capacity.
+// It removes retired machines from the pool, so that concurrent frees
+// do not shrink the pool below capacity.
+func (p *FlyPool) retire(mach *Mach) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if mach.retired.Load() || len(p.machs) > p.capacity {
+		delete(p.machs, mach.Name)
+		p.stateChanged()
+		return true
+	}
+	return false
 }
 
 // handleDiscards handles discarded machines asynchronously with
 // a minimal-effort attempt at destroying the machine.
 // Failures here will be caught by this or another pool's cleanOrphans cleaner.
 func (p *FlyPool) handleDiscards(ctx context.Context) {
-	log.Printf("pool: handleDiscards: started")
-	for mach := range p.discards {
+	slog.Debug("pool: handleDiscards: started")
+	for {
+		mach, err := p.discards.pop(ctx)
+		if err != nil {
+			break
+		}
+
 		if err := mach.destroy(ctx); err != nil {
-			log.Printf("pool: handleDiscards: %v", err)
+			slog.Error("pool: handleDiscards", "err", err)
 			// but continue...
 		}
 
@@ -508,7 +693,7 @@ func (p *FlyPool) handleDiscards(ctx context.Context) {
 			break
 		}
 	}
-	log.Printf("pool: handleDiscards: exiting")
+	slog.Debug("pool: handleDiscards: exiting")
 	p.wg.Done()
 }
 
@@ -526,7 +711,8 @@ func (p *FlyPool) getLease(ctx context.Context, machId string) (*machines.LeaseD
 // cleanMach performs cleanup operations on a machine, if necessary.
 // It destroys machines that do not have an active lease.
 // It adopts machines into the pool if they are owned by this pool instance and not yet in the pool,
-// or destroys them if they are not suitable or needed.
+// or destroys them if they are not suitable or needed. With OwnerKey, machines the pool owns are
+// destroyed with their lease nonce, even if their lease is still active.
 // It returns the number of machines adopted into this pool.
 //
 // Note: This is complicated for efficiency, to avoid doing too many fly API calls.
@@ -538,20 +724,18 @@ func (p *FlyPool) cleanMach(ctx context.Context, m *machines.MachineResp) int {
 	p.mu.Unlock()
 
 	alreadyInOurPool := poolMach != nil
-	poolNameFromName, _ := parseWorkerName(m.Name)
-	ours := m.Config.Metadata[MetaPoolKey] == p.metadata && poolNameFromName == p.name
+	ours := p.ownsMach(m)
 	createdAt, _ := time.Parse(time.RFC3339, m.CreatedAt)
 	age := p.now().Sub(createdAt)
 	probablyExpired := age > p.leaseTime
-	log.Printf("pool: clean: mach %v %v: age=%v, ours=%v inpool=%v", m.Name, m.Id, age, ours, alreadyInOurPool)
+	slog.Debug("pool: clean: mach", "name", m.Name, "mach", m.Id, "age", age, "ours", ours, "inpool", alreadyInOurPool)
 
 	if alreadyInOurPool {
-		// TODO: extend leases here for machines with a lease that is running low?
-
+		// Free machines have their leases renewed by renewLeases.
 		if !poolMach.leaseSufficient(0) {
 			// Destroy it, but leave it in our pool and free queue.
 			// It will get discarded when someone tries to allocate it.
-			log.Printf("pool: clean: mach %v %v: age=%v, destroying, ours", m.Name, m.Id, age)
+			slog.Info("pool: clean: destroying ours", "name", m.Name, "mach", m.Id, "age", age)
 			poolMach.destroy(ctx)
 		}
 		return 0
@@ -560,7 +744,7 @@ func (p *FlyPool) cleanMach(ctx context.Context, m *machines.MachineResp) int {
 	if !ours {
 		if probablyExpired {
 			// Try to destroy it. This wont work if it has a valid lease since we don't provide the lease nonce.
-			log.Printf("pool: clean: mach %v %v: age=%v, destroying, not ours", m.Name, m.Id, age)
+			slog.Info("pool: clean: destroying not ours", "name", m.Name, "mach", m.Id, "age", age)
 			p.api.Destroy(ctx, p.appName, m.Id, true)
 		}
 		return 0
@@ -568,7 +752,7 @@ func (p *FlyPool) cleanMach(ctx context.Context, m *machines.MachineResp) int {
 
 	lease, err := p.getLease(ctx, m.Id)
 	if err != nil {
-		log.Printf("pool: clean: mach %v %v: destroying, error getting lease: %v", m.Name, m.Id, err)
+		slog.Warn("pool: clean: destroying, error getting lease", "name", m.Name, "mach", m.Id, "err", err)
 		p.api.Destroy(ctx, p.appName, m.Id, true)
 		return 0
 	}
@@ -580,6 +764,9 @@ func (p *FlyPool) cleanMach(ctx context.Context, m *machines.MachineResp) int {
 		if !mach.leaseSufficient(p.workerTime) {
 			return fmt.Errorf("lease expiring too soon")
 		}
+		if m.Config.Metadata[MetaPoolKey] != p.metadata {
+			return fmt.Errorf("metadata does not match pool")
+		}
 
 		if err := mach.stop(ctx); err != nil {
 			return err
@@ -592,7 +779,7 @@ func (p *FlyPool) cleanMach(ctx context.Context, m *machines.MachineResp) int {
 		return nil
 	}()
 	if err != nil {
-		log.Printf("pool: clean: mach %v %v: destroying: %v", m.Name, m.Id, err)
+		slog.Warn("pool: clean: destroying", "name", m.Name, "mach", m.Id, "err", err)
 		mach.destroy(ctx)
 		return 0
 	} else {
@@ -600,33 +787,184 @@ func (p *FlyPool) cleanMach(ctx context.Context, m *machines.MachineResp) int {
 	}
 }
 
-func (p *FlyPool) showStats() {
-	for k, stat := range p.stats {
-		log.Printf("pool: stats: %s: %+v", k, stat.Stats())
+// Metrics returns a snapshot of the pool's size and activity.
+func (p *FlyPool) Metrics() *Metrics {
+	p.mu.Lock()
+	capacity := p.capacity
+	size := len(p.machs)
+	p.mu.Unlock()
+
+	m := &Metrics{
+		Capacity: capacity,
+		Size:     size,
+		Free:     p.free.len(),
+		Discards: p.stats.discards.Value(),
+		Adopted:  p.stats.adopted.Value(),
+	}
+	return m
+}
+
+// Machines describes each machine in the pool, sorted by name.
+func (p *FlyPool) Machines() []MachInfo {
+	p.mu.Lock()
+	infos := make([]MachInfo, 0, len(p.machs))
+	for _, mach := range p.machs {
+		info := mach.info()
+		info.Region = p.machRegion
+		infos = append(infos, info)
+	}
+	p.mu.Unlock()
+
+	slices.SortFunc(infos, func(a, b MachInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// SetCapacity changes the most machines the pool will hold.
+// Growing the pool creates machines for any allocations waiting for one,
+// and lets it create more machines as they are needed.
+// Shrinking the pool destroys surplus free machines right away,
+// and retires allocated machines as they are freed.
+// It fails with ErrAutoscaled if the pool has an autoscaler, which would undo the change.
+func (p *FlyPool) SetCapacity(n int) error {
+	if n < 1 {
+		return fmt.Errorf("capacity must be at least 1")
+	}
+	if p.autoscaler != nil {
+		return ErrAutoscaled
+	}
+	p.setCapacity(n)
+	return nil
+}
+
+// setCapacity changes the capacity to n, which must be at least 1.
+func (p *FlyPool) setCapacity(n int) {
+	p.mu.Lock()
+	p.capacity = n
+	surplus := len(p.machs) - n
+	// Waiting allocations only take free machines, so make them some.
+	if grow := min(-surplus, int(p.waiting.Load())); grow > 0 && !p.isShutdown {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.growFree(p.ctx, grow)
+		}()
+	}
+	p.mu.Unlock()
+	slog.Info("pool: set capacity", "capacity", n, "surplus", max(surplus, 0))
+
+	for ; surplus > 0; surplus-- {
+		mach := p.free.tryPop()
+		if mach == nil {
+			break
+		}
+		p.discardMach(mach, "pool shrunk")
+	}
+}
+
+// Clean starts a cleaning pass without waiting for the next scheduled one.
+func (p *FlyPool) Clean() {
+	select {
+	case p.cleanNow <- struct{}{}:
+	default:
 	}
 }
 
+// DiscardMachine removes machine machId from the pool and destroys it.
+// A free machine is discarded immediately, and an allocated machine
+// is discarded when it is freed.
+func (p *FlyPool) DiscardMachine(machId string) error {
+	p.mu.Lock()
+	var mach *Mach
+	for _, m := range p.machs {
+		if m.getId() == machId {
+			mach = m
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	if mach == nil {
+		return ErrNoMachine
+	}
+
+	mach.retired.Store(true)
+	if p.free.remove(mach) {
+		p.discardMach(mach, "discarded by request")
+	}
+	return nil
+}
+
+func (p *FlyPool) showStats() {
+	p.reg.Log("pool: stats", "bfaas_pool_")
+}
+
 func (p *FlyPool) clean(ctx context.Context) {
-	log.Printf("pool: clean: starting")
+	slog.Debug("pool: clean: starting")
 	for {
 		p.showStats()
 
-		log.Printf("pool: cleaning")
-		ms, err := p.api.List(ctx, p.appName, japi.ReqQuery("region", p.machRegion))
-		if err != nil {
-			log.Printf("pool: clean: api.List: %v", err)
-		} else {
-			cnt := 0
-			for _, m := range ms {
-				cnt += p.cleanMach(ctx, &m)
-			}
-			log.Printf("pool: clean: adopted %d machines", cnt)
+		if err := p.cleanOnce(ctx); err != nil {
+			slog.Error("pool: clean", "err", err)
 		}
 
-		if err := sleepWithContext(ctx, cleanerDelay); err != nil {
+		if err := p.sleepUntilClean(ctx); err != nil {
 			break
 		}
 	}
-	log.Printf("pool: clean: exiting")
+	slog.Debug("pool: clean: exiting")
 	p.wg.Done()
 }
+
+// cleanOnce makes one cleaning pass over the pool's machines in its region.
+// Passes are serialized, so machines are not adopted twice.
+// Most passes only list machines with the pool's metadata; see fullCleanEvery.
+func (p *FlyPool) cleanOnce(ctx context.Context) error {
+	p.cleanMu.Lock()
+	defer p.cleanMu.Unlock()
+
+	full := p.cleanPasses%fullCleanEvery == 0
+	p.cleanPasses++
+
+	slog.Debug("pool: cleaning", "full", full)
+	opts := []machines.ReqOpt{machines.ListRegion(p.machRegion)}
+	if !full {
+		opts = append(opts, machines.ListMetadata(MetaPoolKey, p.metadata))
+	}
+	ms, err := p.api.List(ctx, p.appName, opts...)
+	if err != nil {
+		return fmt.Errorf("api.List: %w", err)
+	}
+
+	if p.stateReconcile {
+		p.reconcileState(ctx, ms)
+		p.stateReconcile = false
+	}
+
+	cnt := 0
+	for _, m := range ms {
+		cnt += p.cleanMach(ctx, &m)
+	}
+	slog.Info("pool: clean: adopted machines", "count", cnt)
+	p.stats.adopted.Add(int64(cnt))
+	return nil
+}
+
+// Reconcile makes a cleaning pass and waits for it, adopting any machines
+// left by an earlier instance of the pool. The background cleaner makes
+// the same pass when the pool starts, but doesn't hold up allocations.
+func (p *FlyPool) Reconcile(ctx context.Context) error {
+	slog.Info("pool: reconcile", "region", p.machRegion)
+	return p.cleanOnce(ctx)
+}
+
+// sleepUntilClean waits for the next scheduled cleaning, or for Clean to be called.
+func (p *FlyPool) sleepUntilClean(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(cleanerDelay):
+		return nil
+	case <-p.cleanNow:
+		return nil
+	}
+}
@@ -67,3 +67,58 @@ func TestPool(t *testing.T) {
 	err = pool.Destroy()
 	assert.NoError(t, err)
 }
+
+func TestParseWorkerName(t *testing.T) {
+	name := newWorkerName("m8001-large-cpu")
+	poolName, suffix, err := parseWorkerName(name)
+	assert.NoError(t, err)
+	assert.Equal(t, "m8001-large-cpu", poolName)
+	assert.NotZero(t, suffix)
+
+	for _, bad := range []string{"", "worker", "worker-123", "worker-pool-", "other-pool-123"} {
+		_, _, err := parseWorkerName(bad)
+		assert.Error(t, err)
+	}
+}
+
+func TestSetCapacityWakesWaiters(t *testing.T) {
+	p, _ := newTestPool(t, 1)
+	mach, err := p.allocLeased(context.Background(), false)
+	assert.NoError(t, err)
+	assert.NotZero(t, mach)
+
+	// The pool is full, so this waits for a free machine.
+	got := make(chan *Mach)
+	go func() {
+		m, err := p.allocLeased(context.Background(), true)
+		assert.NoError(t, err)
+		got <- m
+	}()
+	for p.waiting.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.NoError(t, p.SetCapacity(2))
+	select {
+	case m := <-got:
+		assert.True(t, m != nil && m != mach)
+		assert.Equal(t, "started", m.getState())
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter not woken by SetCapacity")
+	}
+	assert.Equal(t, 2, len(p.machs))
+}
+
+func TestGrowPoolFailure(t *testing.T) {
+	p, f := newTestPool(t, 1)
+	f.failCreate.Store(true)
+	_, err := p.growPool(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(p.machs))
+
+	// The slot is free again.
+	f.failCreate.Store(false)
+	mach, err := p.growPool(context.Background())
+	assert.NoError(t, err)
+	assert.NotZero(t, mach)
+}
@@ -1,6 +1,29 @@
 package pool
 
-import "context"
+import (
+	"context"
+	"time"
+)
+
+// Metrics is a snapshot of a pool's size and activity.
+// Detailed statistics are kept in the stats.Registry given to the pool.
+type Metrics struct {
+	Capacity int
+	Size     int
+	Free     int
+	Discards int64
+	Adopted  int64
+}
+
+// MachInfo describes a machine in a pool.
+type MachInfo struct {
+	Name         string    `json:"name"`
+	Id           string    `json:"id"`
+	Region       string    `json:"region,omitempty"`
+	State        string    `json:"state"`
+	LeaseExpires time.Time `json:"lease_expires"`
+	Free         bool      `json:"free"`
+}
 
 type Pool interface {
 	// Close shuts down the pool and leaves workers running in the "stopped" state.
@@ -19,5 +42,31 @@ type Pool interface {
 	// to alloc that is waiting for a free machine.
 	// It is an error to call Free after the pool has been closed, and
 	// could result in a panic.
+	//
+	// mach.Discard removes a machine from the pool and destroys it.
+	// It is used instead of mach.Free for machines that misbehaved.
 	Alloc(ctx context.Context, waitForFree bool) (*Mach, error)
+
+	// Owns returns true if machId is one of the pool's machines.
+	Owns(machId string) bool
+
+	// Metrics returns a snapshot of the pool's size and activity.
+	Metrics() *Metrics
+
+	// Machines describes each machine in the pool, sorted by name.
+	Machines() []MachInfo
+
+	// SetCapacity changes the most machines the pool will hold.
+	SetCapacity(n int) error
+
+	// Clean starts a cleaning pass without waiting for the next scheduled one.
+	Clean()
+
+	// Reconcile makes a cleaning pass and waits for it, so that machines left by
+	// an earlier instance of the pool are adopted before the pool is used.
+	Reconcile(ctx context.Context) error
+
+	// DiscardMachine removes machine machId from the pool and destroys it.
+	// A machine that is allocated is discarded when it is freed.
+	DiscardMachine(machId string) error
 }
@@ -3,8 +3,10 @@ package pool
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/superfly/coordBfaas/japi"
@@ -34,10 +36,33 @@ type Mach struct {
 	// result in a panic.
 	Free func()
 
-	pool         *FlyPool
+	// Discard removes a machine from the pool and destroys it, instead of
+	// returning it to the pool. It should be used in place of Free when the
+	// machine is suspected to be misbehaving.
+	Discard func(reason string)
+
+	// released is set once Free or Discard has been called for an allocation,
+	// and is set while the machine is not allocated.
+	released atomic.Bool
+
+	// retired is set when the machine should be discarded instead of freed.
+	retired atomic.Bool
+
+	// freeCtx carries the trace of the allocation into Free.
+	freeCtx context.Context
+
+	pool *FlyPool
+
+	// mu guards Id, InstanceId and the fields below, which the cleaner, the state writer
+	// and the admin API read while the machine is in use. Only the goroutine using the
+	// machine changes Id, InstanceId and leaseNonce, so it may read them without mu.
+	mu           sync.Mutex
 	leaseNonce   string
 	leaseExpires time.Time
 	state        string
+
+	// fromState is set for machines adopted from the pool's state file.
+	fromState bool
 }
 
 // newMachNascent makes a pre-created Mach. Caller must fill in Id, leaseNonce, and InstanceId once started.
@@ -52,8 +77,10 @@ func newMachNascent(p *FlyPool, name string, leaseExpires time.Time) *Mach {
 		leaseExpires: leaseExpires,
 		leaseNonce:   "",
 		state:        "nascent",
+		freeCtx:      context.Background(),
 	}
-	m.Free = func() { p.freeMach(m) }
+	m.released.Store(true)
+	m.setRelease(func() { p.freeMach(m) }, func(msg string) { p.discardMach(m, msg) })
 	return m
 }
 
@@ -69,44 +96,60 @@ func newMachFromFly(p *FlyPool, flym *machines.MachineResp, leaseNonce string, l
 		leaseExpires: leaseExpires,
 		leaseNonce:   leaseNonce,
 		state:        flym.State,
+		freeCtx:      context.Background(),
 	}
-	m.Free = func() { p.freeMach(m) }
+	m.released.Store(true)
+	m.setRelease(func() { p.freeMach(m) }, func(msg string) { p.discardMach(m, msg) })
 	return m
 }
 
+// setRelease sets the Free and Discard functions so that only the first
+// call to either of them takes effect for each allocation.
+func (mach *Mach) setRelease(free func(), discard func(string)) {
+	mach.Free = func() {
+		if mach.released.CompareAndSwap(false, true) {
+			free()
+		}
+	}
+	mach.Discard = func(reason string) {
+		if mach.released.CompareAndSwap(false, true) {
+			discard(reason)
+		}
+	}
+}
+
 func (mach *Mach) waitFor(ctx context.Context, state string) error {
 	if mach.Id == "" {
 		return fmt.Errorf("pool: waitFor %s %s %s: cant wait for nascent machine", mach.pool.appName, mach.Name, state)
 	}
 
-	log.Printf("pool: wait for %s %s %s %s", mach.pool.appName, mach.Name, mach.Id, state)
+	slog.DebugContext(ctx, "pool: wait for", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id, "state", state)
 	nonceOpt := machines.LeaseNonce(mach.leaseNonce)
 	ok, err := mach.pool.api.WaitFor(ctx, mach.pool.appName, mach.Id, mach.InstanceId, 60*time.Second, state, nonceOpt)
 	err = checkOk(ok, err)
 	if err != nil {
-		log.Printf("pool: wait for %s %s %s %s: %v", mach.pool.appName, mach.Name, mach.Id, state, err)
+		slog.WarnContext(ctx, "pool: wait for", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id, "state", state, "err", err)
 		return fmt.Errorf("api.WaitFor %s %s %v: %w", mach.Name, mach.Id, state, err)
 	}
-	//log.Printf("pool: wait for %s %s %s %s: done", mach.pool.appName, mach.Name, mach.Id, state)
-	mach.state = state
+	//slog.DebugContext(ctx, "pool: wait for: done", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id, "state", state)
+	mach.setState(state)
 	return nil
 }
 
-func (mach *Mach) start(ctx context.Context) error {
+func (mach *Mach) start(ctx context.Context) (err error) {
 	if mach.Id == "" {
 		return fmt.Errorf("pool: start %s %s: cant start nascent machine", mach.pool.appName, mach.Name)
 	}
 
-	log.Printf("pool: start %s %s %s", mach.pool.appName, mach.Name, mach.Id)
-	if mach.state == "started" {
+	slog.DebugContext(ctx, "pool: start", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id)
+	if mach.getState() == "started" {
 		return nil
 	}
 
-	dt := mach.pool.stats[statsStart].Start()
-	defer dt.End()
+	ctx, done := track(ctx, "start", mach.pool.stats.start)
+	defer func() { done(err) }()
 
 	// Retry on 412 PreconditionFailed, which indicates that the machine is not fully stopped yet.
-	var err error
 	nonceOpt := machines.LeaseNonce(mach.leaseNonce)
 	wait := startRetryWait
 	for times := 0; times < startRetryTimes; times += 1 {
@@ -115,7 +158,7 @@ func (mach *Mach) start(ctx context.Context) error {
 			break
 		}
 
-		log.Printf("pool: start %s %s %s: %s, retrying", mach.pool.appName, mach.Name, mach.Id, err)
+		slog.InfoContext(ctx, "pool: start: retrying", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id, "err", err)
 		time.Sleep(wait)
 		wait = 2 * wait
 	}
@@ -126,25 +169,33 @@ func (mach *Mach) start(ctx context.Context) error {
 	if err := mach.waitFor(ctx, "started"); err != nil {
 		return err
 	}
-	//log.Printf("pool: start %s %s %s: done", mach.pool.appName, mach.Name, mach.Id)
+	//slog.DebugContext(ctx, "pool: start: done", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id)
 	return nil
 }
 
-func (mach *Mach) stop(ctx context.Context) error {
+// waitReady waits for the worker to answer its readiness check.
+func (mach *Mach) waitReady(ctx context.Context) (err error) {
+	ctx, done := track(ctx, "ready", mach.pool.stats.ready)
+	defer func() { done(err) }()
+
+	return waitReady(ctx, mach.Url, mach.Id, mach.pool.readyTime)
+}
+
+func (mach *Mach) stop(ctx context.Context) (err error) {
 	if mach.Id == "" {
 		return fmt.Errorf("pool: stop %s %s: cant stop nascent machine", mach.pool.appName, mach.Name)
 	}
 
-	if mach.state == "stopped" {
+	if mach.getState() == "stopped" {
 		return nil
 	}
 
-	dt := mach.pool.stats[statsStop].Start()
-	defer dt.End()
+	ctx, done := track(ctx, "stop", mach.pool.stats.stop)
+	defer func() { done(err) }()
 
-	log.Printf("pool: stop %s %s %s", mach.pool.appName, mach.Name, mach.Id)
+	slog.DebugContext(ctx, "pool: stop", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id)
 	nonceOpt := machines.LeaseNonce(mach.leaseNonce)
-	_, err := mach.pool.api.Stop(ctx, mach.pool.appName, mach.Id, nonceOpt)
+	_, err = mach.pool.api.Stop(ctx, mach.pool.appName, mach.Id, nonceOpt)
 	if err != nil {
 		return fmt.Errorf("api.Stop %s %s: %w", mach.Name, mach.Id, err)
 	}
@@ -152,34 +203,39 @@ func (mach *Mach) stop(ctx context.Context) error {
 	if err := mach.waitFor(ctx, "stopped"); err != nil {
 		return err
 	}
-	//log.Printf("pool: stop %s %s %s: done", mach.pool.appName, mach.Name, mach.Id)
+	//slog.DebugContext(ctx, "pool: stop: done", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id)
 	return nil
 }
 
-func (mach *Mach) destroy(ctx context.Context) error {
-	if mach.Id == "" {
+// destroy destroys the machine. The cleaner may call it while the machine is in use.
+func (mach *Mach) destroy(ctx context.Context) (err error) {
+	mach.mu.Lock()
+	id, nonce := mach.Id, mach.leaseNonce
+	if id != "" {
+		mach.state = "destroyed"
+	}
+	mach.mu.Unlock()
+	if id == "" {
 		return nil
 	}
 
-	dt := mach.pool.stats[statsDestroy].Start()
-	defer dt.End()
+	ctx, done := track(ctx, "destroy", mach.pool.stats.destroy)
+	defer func() { done(err) }()
 
-	log.Printf("pool: destroy %s %s %s", mach.pool.appName, mach.Name, mach.Id)
-	mach.state = "destroyed"
-	nonceOpt := machines.LeaseNonce(mach.leaseNonce)
-	ok, err := mach.pool.api.Destroy(ctx, mach.pool.appName, mach.Id, true, nonceOpt)
+	slog.InfoContext(ctx, "pool: destroy", "app", mach.pool.appName, "name", mach.Name, "mach", id)
+	ok, err := mach.pool.api.Destroy(ctx, mach.pool.appName, id, true, machines.LeaseNonce(nonce))
 	err = checkOk(ok, err)
 	if err != nil {
-		return fmt.Errorf("api.Destroy %s %s: %w", mach.Id, mach.Name, err)
+		return fmt.Errorf("api.Destroy %s %s: %w", id, mach.Name, err)
 	}
 	return nil
 }
 
-func (mach *Mach) updateLease(ctx context.Context, exp time.Time) error {
-	dt := mach.pool.stats[statsLease].Start()
-	defer dt.End()
+func (mach *Mach) updateLease(ctx context.Context, exp time.Time) (err error) {
+	ctx, done := track(ctx, "lease", mach.pool.stats.lease)
+	defer func() { done(err) }()
 
-	log.Printf("pool: updateLease %s %s %s", mach.pool.appName, mach.Name, mach.Id)
+	slog.DebugContext(ctx, "pool: updateLease", "app", mach.pool.appName, "name", mach.Name, "mach", mach.Id)
 	ttl := int(exp.Sub(mach.pool.now()).Seconds())
 	nonceOpt := machines.LeaseNonce(mach.leaseNonce)
 	lease, err := mach.pool.api.Lease(ctx, mach.pool.appName, mach.Id, &machines.LeaseReq{Ttl: ttl}, nonceOpt)
@@ -190,12 +246,54 @@ func (mach *Mach) updateLease(ctx context.Context, exp time.Time) error {
 		return fmt.Errorf("api.Lease %s %s: bad status %s", mach.Id, mach.Name, lease.Status)
 	}
 
+	mach.mu.Lock()
 	mach.leaseExpires = exp
+	mach.mu.Unlock()
+	mach.pool.stateChanged()
 	return nil
 }
 
+func (mach *Mach) getState() string {
+	mach.mu.Lock()
+	defer mach.mu.Unlock()
+	return mach.state
+}
+
+func (mach *Mach) setState(state string) {
+	mach.mu.Lock()
+	defer mach.mu.Unlock()
+	mach.state = state
+}
+
+// expires returns when the machine's lease expires.
+func (mach *Mach) expires() time.Time {
+	mach.mu.Lock()
+	defer mach.mu.Unlock()
+	return mach.leaseExpires
+}
+
+// getId returns the machine's ID, which is empty until it is created.
+func (mach *Mach) getId() string {
+	mach.mu.Lock()
+	defer mach.mu.Unlock()
+	return mach.Id
+}
+
+// info describes the machine.
+func (mach *Mach) info() MachInfo {
+	mach.mu.Lock()
+	defer mach.mu.Unlock()
+	return MachInfo{
+		Name:         mach.Name,
+		Id:           mach.Id,
+		State:        mach.state,
+		LeaseExpires: mach.leaseExpires,
+		Free:         mach.released.Load(),
+	}
+}
+
 // leaseSufficient returns true if the lease has at least dt time left.
 func (mach *Mach) leaseSufficient(dt time.Duration) bool {
 	needUntil := mach.pool.now().Add(dt)
-	return mach.leaseExpires.After(needUntil)
+	return mach.expires().After(needUntil)
 }
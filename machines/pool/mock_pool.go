@@ -3,7 +3,7 @@ package pool
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
 	"time"
 )
@@ -11,6 +11,7 @@ import (
 const mockMachId = "m8001"
 const mockInstanceId = "INSTANCEID"
 const mockUrl = "http://localhost:8001"
+const mockReadyTime = 10 * time.Second
 
 // MockPool is a mock pool of machines of size 1.
 type MockPool struct {
@@ -38,7 +39,9 @@ func NewMock(cmd string, arg ...string) *MockPool {
 		Id:         mockMachId,
 		InstanceId: mockInstanceId,
 	}
-	mach.Free = func() { p.freeMach(mach) }
+	// The mock pool has only one machine, so discarding just frees it.
+	mach.released.Store(true)
+	mach.setRelease(func() { p.freeMach(mach) }, func(string) { p.freeMach(mach) })
 	p.mach = mach
 	p.free <- mach
 
@@ -46,7 +49,7 @@ func NewMock(cmd string, arg ...string) *MockPool {
 }
 
 func (p *MockPool) Close() error {
-	log.Printf("mock pool: close")
+	slog.Info("mock pool: close")
 	p.mach.Free()
 	close(p.free)
 	return nil
@@ -57,37 +60,77 @@ func (p *MockPool) Destroy() error {
 }
 
 func (p *MockPool) Alloc(ctx context.Context, waitForMachine bool) (*Mach, error) {
-	log.Printf("mock pool: alloc wait")
+	slog.DebugContext(ctx, "mock pool: alloc wait")
 	var mach *Mach
 	select {
 	case <-ctx.Done():
-		log.Printf("mock pool: alloc cancelled context")
+		slog.InfoContext(ctx, "mock pool: alloc cancelled context")
 		return nil, ctx.Err()
 	case mach = <-p.free:
 		if mach == nil {
-			log.Printf("mock pool: alloc cancelled with closed pool")
+			slog.InfoContext(ctx, "mock pool: alloc cancelled with closed pool")
 			return nil, ErrPoolClosed
 		}
 		// continue with mach...
 	}
 
-	log.Printf("mock pool: starting machine %s", mach.Id)
+	slog.InfoContext(ctx, "mock pool: starting machine", "mach", mach.Id)
+	mach.released.Store(false)
 	cmdctx, cancel := context.WithCancel(ctx)
 	cmd := exec.CommandContext(cmdctx, p.cmd, p.arg...)
 	if err := cmd.Start(); err != nil {
+		cancel()
 		return nil, fmt.Errorf("Command.Start: %w", err)
 	}
 
-	// hack: give the process a little time to start up.
-	time.Sleep(time.Second)
 	p.cancel = cancel
+	if err := waitReady(ctx, mach.Url, mach.Id, mockReadyTime); err != nil {
+		slog.ErrorContext(ctx, "mock pool: waitReady", "err", err)
+		mach.Free()
+		return nil, err
+	}
 
-	log.Printf("mock pool: started machine %s", mach.Id)
+	slog.InfoContext(ctx, "mock pool: started machine", "mach", mach.Id)
 	return mach, nil
 }
 
+func (p *MockPool) Owns(machId string) bool {
+	return machId == mockMachId
+}
+
+func (p *MockPool) Metrics() *Metrics {
+	return &Metrics{
+		Capacity: 1,
+		Size:     1,
+		Free:     len(p.free),
+	}
+}
+
+func (p *MockPool) Machines() []MachInfo {
+	return []MachInfo{p.mach.info()}
+}
+
+func (p *MockPool) SetCapacity(n int) error {
+	if n != 1 {
+		return fmt.Errorf("mock pool capacity is always 1")
+	}
+	return nil
+}
+
+func (p *MockPool) Clean() {}
+
+func (p *MockPool) Reconcile(ctx context.Context) error { return nil }
+
+func (p *MockPool) DiscardMachine(machId string) error {
+	if machId != mockMachId {
+		return ErrNoMachine
+	}
+	// The mock pool has only one machine, so there is nothing to destroy.
+	return nil
+}
+
 func (p *MockPool) freeMach(mach *Mach) {
-	log.Printf("pool: free %s", mach.Id)
+	slog.Info("mock pool: free", "mach", mach.Id)
 	if p.cancel != nil {
 		p.cancel()
 		p.cancel = nil
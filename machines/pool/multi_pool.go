@@ -0,0 +1,236 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/superfly/coordBfaas/machines"
+)
+
+// RegionSize is the capacity of one region of a MultiRegionPool.
+type RegionSize struct {
+	Region   string
+	Capacity int
+}
+
+// MultiRegionPool is a pool of Fly machines spread over several regions.
+// Each region has its own FlyPool. Allocations are made from the regions
+// in preference order, so the first region should be the nearest, and the
+// rest in order of increasing latency.
+type MultiRegionPool struct {
+	regions []RegionSize
+	pools   []*FlyPool
+}
+
+var _ Pool = (*MultiRegionPool)(nil)
+
+// NewMultiRegion creates a pool with a FlyPool for each region, in preference order.
+// The region pools share poolName, which is safe since each pool only cleans its own region.
+// Opts are applied to every region pool, before its Region and Size.
+func NewMultiRegion(api *machines.Api, poolName, appName, image string, regions []RegionSize, opts ...Opt) (*MultiRegionPool, error) {
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("no regions")
+	}
+
+	p := &MultiRegionPool{regions: regions}
+	for _, r := range regions {
+		ropts := append(slices.Clone(opts), Region(r.Region), Size(r.Capacity))
+		rp, err := New(api, poolName, appName, image, ropts...)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("region %s: %w", r.Region, err)
+		}
+		p.pools = append(p.pools, rp)
+	}
+	return p, nil
+}
+
+// ParseRegions parses a list of regions and capacities in preference order,
+// such as "qmx:2,dfw:1".
+func ParseRegions(s string) ([]RegionSize, error) {
+	var regions []RegionSize
+	for _, w := range strings.Split(s, ",") {
+		var r RegionSize
+		name, capacity, ok := strings.Cut(strings.TrimSpace(w), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("bad region %q, want region:capacity", w)
+		}
+		if _, err := fmt.Sscanf(capacity, "%d", &r.Capacity); err != nil || r.Capacity < 1 {
+			return nil, fmt.Errorf("bad capacity for region %q", name)
+		}
+		r.Region = name
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+func (p *MultiRegionPool) Close() error {
+	var err error
+	for _, rp := range p.pools {
+		err = errors.Join(err, rp.Close())
+	}
+	return err
+}
+
+func (p *MultiRegionPool) Destroy() error {
+	var err error
+	for _, rp := range p.pools {
+		err = errors.Join(err, rp.Destroy())
+	}
+	return err
+}
+
+// Alloc allocates a machine from the first region that has one free,
+// or can create one. If none can, and waitForFree is true, it waits for
+// a machine in the preferred region.
+// A region that fails to allocate is skipped, and its error is returned
+// only if no other region has a machine.
+func (p *MultiRegionPool) Alloc(ctx context.Context, waitForFree bool) (*Mach, error) {
+	var errs error
+	for _, rp := range p.pools {
+		mach, err := rp.Alloc(ctx, false)
+		if err != nil {
+			slog.WarnContext(ctx, "pool: multi: alloc", "region", rp.machRegion, "err", err)
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if mach != nil {
+			return mach, nil
+		}
+	}
+
+	if errs != nil || !waitForFree {
+		return nil, errs
+	}
+	return p.pools[0].Alloc(ctx, true)
+}
+
+func (p *MultiRegionPool) Owns(machId string) bool {
+	for _, rp := range p.pools {
+		if rp.Owns(machId) {
+			return true
+		}
+	}
+	return false
+}
+
+// Metrics returns the totals over all regions.
+func (p *MultiRegionPool) Metrics() *Metrics {
+	total := &Metrics{}
+	for _, rp := range p.pools {
+		m := rp.Metrics()
+		total.Capacity += m.Capacity
+		total.Size += m.Size
+		total.Free += m.Free
+		total.Discards += m.Discards
+		total.Adopted += m.Adopted
+	}
+	return total
+}
+
+func (p *MultiRegionPool) Machines() []MachInfo {
+	var infos []MachInfo
+	for _, rp := range p.pools {
+		infos = append(infos, rp.Machines()...)
+	}
+	slices.SortFunc(infos, func(a, b MachInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// SetCapacity splits n machines over the regions in proportion to their
+// initial capacities, giving any remainder to the preferred regions.
+// Every region keeps at least one machine.
+func (p *MultiRegionPool) SetCapacity(n int) error {
+	caps, err := splitCapacity(n, p.regions)
+	if err != nil {
+		return err
+	}
+
+	for i, rp := range p.pools {
+		if err := rp.SetCapacity(caps[i]); err != nil {
+			return fmt.Errorf("region %s: %w", rp.machRegion, err)
+		}
+	}
+	return nil
+}
+
+// SetRegionCapacity changes the capacity of a single region.
+func (p *MultiRegionPool) SetRegionCapacity(region string, n int) error {
+	for _, rp := range p.pools {
+		if rp.machRegion == region {
+			return rp.SetCapacity(n)
+		}
+	}
+	return fmt.Errorf("no region %q in pool", region)
+}
+
+func (p *MultiRegionPool) Clean() {
+	for _, rp := range p.pools {
+		rp.Clean()
+	}
+}
+
+// Reconcile reconciles every region at once, returning the errors from any that fail.
+func (p *MultiRegionPool) Reconcile(ctx context.Context) error {
+	errs := make([]error, len(p.pools))
+	var wg sync.WaitGroup
+	for i, rp := range p.pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rp.Reconcile(ctx); err != nil {
+				errs[i] = fmt.Errorf("region %s: %w", rp.machRegion, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (p *MultiRegionPool) DiscardMachine(machId string) error {
+	for _, rp := range p.pools {
+		err := rp.DiscardMachine(machId)
+		if !errors.Is(err, ErrNoMachine) {
+			return err
+		}
+	}
+	return ErrNoMachine
+}
+
+// splitCapacity splits n over regions in proportion to their capacities,
+// with at least one each, giving the remainder to regions in preference order.
+func splitCapacity(n int, regions []RegionSize) ([]int, error) {
+	if n < len(regions) {
+		return nil, fmt.Errorf("capacity must be at least %d, one per region", len(regions))
+	}
+
+	total := 0
+	for _, r := range regions {
+		total += r.Capacity
+	}
+
+	caps := make([]int, len(regions))
+	left := n
+	for i, r := range regions {
+		caps[i] = max(1, n*r.Capacity/total)
+		left -= caps[i]
+	}
+	for i := 0; left > 0; i = (i + 1) % len(caps) {
+		caps[i]++
+		left--
+	}
+	for left < 0 {
+		for i := len(caps) - 1; i >= 0 && left < 0; i-- {
+			if caps[i] > 1 {
+				caps[i]--
+				left++
+			}
+		}
+	}
+	return caps, nil
+}
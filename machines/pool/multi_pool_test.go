@@ -0,0 +1,40 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseRegions(t *testing.T) {
+	regions, err := ParseRegions("qmx:2, dfw:1")
+	assert.NoError(t, err)
+	assert.Equal(t, []RegionSize{{"qmx", 2}, {"dfw", 1}}, regions)
+
+	for _, bad := range []string{"", "qmx", "qmx:0", "qmx:x", ":2"} {
+		_, err := ParseRegions(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSplitCapacity(t *testing.T) {
+	regions := []RegionSize{{"qmx", 2}, {"dfw", 1}, {"ord", 1}}
+	cases := []struct {
+		n    int
+		want []int
+	}{
+		{4, []int{2, 1, 1}},
+		{8, []int{4, 2, 2}},
+		{5, []int{3, 1, 1}},
+		{3, []int{1, 1, 1}},
+		{10, []int{6, 2, 2}},
+	}
+	for _, c := range cases {
+		caps, err := splitCapacity(c.n, regions)
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, caps, "n=%d", c.n)
+	}
+
+	_, err := splitCapacity(2, regions)
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package pool
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/superfly/coordBfaas/machines"
+)
+
+// ownerIdSize and ownerMacSize are the sizes, in bytes, of the random ID
+// and truncated HMAC in signed worker names.
+const (
+	ownerIdSize  = 8
+	ownerMacSize = 8
+)
+
+// OwnerKey makes the pool sign the names of the machines it creates with key,
+// so that it can tell which machines it owns without trusting their metadata,
+// which workers can change. Pools with the same name and key own each other's
+// machines, so a restarted pool can find and destroy what its last instance left.
+func OwnerKey(key []byte) Opt {
+	return func(p *FlyPool) { p.ownerKey = key }
+}
+
+// newWorkerName returns a new name for a worker machine,
+// signed if the pool has an owner key.
+func (p *FlyPool) newWorkerName() string {
+	if p.ownerKey == nil {
+		return newWorkerName(p.name)
+	}
+
+	buf := make([]byte, ownerIdSize)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+	return fmt.Sprintf("worker-%s-%s%s", p.name, id, p.nameMac(id))
+}
+
+// nameMac returns the HMAC of the pool name and id, in hex.
+func (p *FlyPool) nameMac(id string) string {
+	mac := hmac.New(sha256.New, p.ownerKey)
+	fmt.Fprintf(mac, "%s\x00%s", p.name, id)
+	return hex.EncodeToString(mac.Sum(nil)[:ownerMacSize])
+}
+
+// ownsName returns true if name is a worker name signed by this pool's owner key.
+func (p *FlyPool) ownsName(name string) bool {
+	poolName, suffix, err := parseWorkerName(name)
+	if err != nil || poolName != p.name || len(suffix) != 2*(ownerIdSize+ownerMacSize) {
+		return false
+	}
+	id, mac := suffix[:2*ownerIdSize], suffix[2*ownerIdSize:]
+	return hmac.Equal([]byte(mac), []byte(p.nameMac(id)))
+}
+
+// ownsMach returns true if m was created by this pool, or an earlier instance of it.
+// With an owner key, this is proven by the machine's name. Without one,
+// it trusts the machine's metadata.
+func (p *FlyPool) ownsMach(m *machines.MachineResp) bool {
+	if p.ownerKey != nil {
+		return p.ownsName(m.Name)
+	}
+	poolName, _, _ := parseWorkerName(m.Name)
+	return m.Config.Metadata[MetaPoolKey] == p.metadata && poolName == p.name
+}
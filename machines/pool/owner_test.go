@@ -0,0 +1,43 @@
+package pool
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/superfly/coordBfaas/machines"
+)
+
+func TestOwnsMach(t *testing.T) {
+	p := &FlyPool{name: "m8001", metadata: "m8001//image", ownerKey: []byte("secret")}
+	name := p.newWorkerName()
+	mach := func(name, meta string) *machines.MachineResp {
+		m := &machines.MachineResp{Name: name}
+		m.Config.Metadata = map[string]string{MetaPoolKey: meta}
+		return m
+	}
+
+	// Signed names are owned whatever their metadata says.
+	assert.True(t, p.ownsMach(mach(name, p.metadata)))
+	assert.True(t, p.ownsMach(mach(name, "tampered")))
+
+	// Unsigned, tampered and other pools' names are not.
+	assert.False(t, p.ownsMach(mach(newWorkerName(p.name), p.metadata)))
+	last := name[len(name)-1:]
+	flipped := "0"
+	if last == "0" {
+		flipped = "1"
+	}
+	assert.False(t, p.ownsMach(mach(name[:len(name)-1]+flipped, p.metadata)))
+	other := &FlyPool{name: "m8002", ownerKey: []byte("secret")}
+	assert.False(t, p.ownsMach(mach(other.newWorkerName(), p.metadata)))
+	assert.False(t, p.ownsMach(mach(strings.Replace(name, "m8001", "m8002", 1), p.metadata)))
+	rekeyed := &FlyPool{name: "m8001", ownerKey: []byte("other")}
+	assert.False(t, p.ownsMach(mach(rekeyed.newWorkerName(), p.metadata)))
+
+	// Without a key, ownership comes from the metadata.
+	p.ownerKey = nil
+	assert.True(t, p.ownsMach(mach(newWorkerName(p.name), p.metadata)))
+	assert.False(t, p.ownsMach(mach(newWorkerName(p.name), "tampered")))
+}
@@ -0,0 +1,137 @@
+package pool
+
+import (
+	"context"
+	"sync"
+)
+
+// machQueue is an unbounded FIFO queue of machines that can be waited on.
+// Unlike a channel, it does not need to be sized up front, and machines
+// can be removed from the middle of it.
+type machQueue struct {
+	mu     sync.Mutex
+	machs  []*Mach
+	closed bool
+
+	// ready has a value when machs may be non-empty, and is closed when the queue is closed.
+	ready chan struct{}
+}
+
+func newMachQueue() *machQueue {
+	return &machQueue{ready: make(chan struct{}, 1)}
+}
+
+// signal wakes a waiter. Must be called with the lock held.
+func (q *machQueue) signal() {
+	if q.closed {
+		return
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push adds mach to the end of the queue.
+// It returns false if the queue is closed.
+func (q *machQueue) push(mach *Mach) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+	q.machs = append(q.machs, mach)
+	q.signal()
+	return true
+}
+
+// tryPop removes and returns the machine at the front of the queue,
+// or nil if the queue is empty.
+func (q *machQueue) tryPop() *Mach {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.machs) == 0 {
+		return nil
+	}
+	mach := q.machs[0]
+	q.machs[0] = nil
+	q.machs = q.machs[1:]
+	if len(q.machs) > 0 {
+		q.signal()
+	}
+	return mach
+}
+
+// pop removes and returns the machine at the front of the queue,
+// waiting for one if the queue is empty.
+// It returns ErrPoolClosed if the queue is closed and empty.
+func (q *machQueue) pop(ctx context.Context) (*Mach, error) {
+	for {
+		if mach := q.tryPop(); mach != nil {
+			return mach, nil
+		}
+
+		q.mu.Lock()
+		closed := q.closed && len(q.machs) == 0
+		q.mu.Unlock()
+		if closed {
+			return nil, ErrPoolClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.ready:
+		}
+	}
+}
+
+// remove removes mach from the queue, returning false if it was not in the queue.
+func (q *machQueue) remove(mach *Mach) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, m := range q.machs {
+		if m == mach {
+			q.machs = append(q.machs[:i], q.machs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the machines in the queue for which match returns true, leaving them in the queue.
+// Match is called with the queue locked, so it may read machines that nobody else has popped.
+func (q *machQueue) find(match func(*Mach) bool) []*Mach {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var found []*Mach
+	for _, m := range q.machs {
+		if match(m) {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+// len returns the number of machines in the queue.
+func (q *machQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.machs)
+}
+
+// close stops the queue from accepting machines and wakes any waiters.
+// Machines already in the queue can still be popped.
+func (q *machQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.ready)
+	}
+}
@@ -0,0 +1,56 @@
+package pool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestMachQueue(t *testing.T) {
+	q := newMachQueue()
+	a, b, c := &Mach{Name: "a"}, &Mach{Name: "b"}, &Mach{Name: "c"}
+	assert.True(t, q.push(a))
+	assert.True(t, q.push(b))
+	assert.True(t, q.push(c))
+	assert.Equal(t, 3, q.len())
+
+	assert.Equal(t, []*Mach{a, c}, q.find(func(m *Mach) bool { return m != b }))
+	assert.Equal(t, 3, q.len())
+
+	assert.True(t, q.remove(b))
+	assert.False(t, q.remove(b))
+	assert.Equal(t, a, q.tryPop())
+
+	ctx := context.Background()
+	m, err := q.pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, c, m)
+	assert.Zero(t, q.tryPop())
+
+	// pop waits for a push.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.push(a)
+	}()
+	m, err = q.pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, a, m)
+
+	// pop gives up when ctx is done.
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = q.pop(tctx)
+	assert.IsError(t, err, context.DeadlineExceeded)
+
+	// machines queued before close can still be popped.
+	q.push(b)
+	q.close()
+	assert.False(t, q.push(c))
+	m, err = q.pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, b, m)
+	_, err = q.pop(ctx)
+	assert.IsError(t, err, ErrPoolClosed)
+}
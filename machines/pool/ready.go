@@ -0,0 +1,64 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const ReadyPath = "/readyz"
+
+var ErrWorkerBoot = fmt.Errorf("worker failed to boot")
+
+var readyClient = &http.Client{}
+var readyPollMin = 10 * time.Millisecond
+var readyPollMax = 200 * time.Millisecond
+
+// checkReady makes a single readiness request to the worker.
+func checkReady(ctx context.Context, url, machId string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url+ReadyPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("fly-force-instance-id", machId)
+
+	resp, err := readyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if id := resp.Header.Get("Worker"); id != machId {
+		return fmt.Errorf("answered by %q", id)
+	}
+	return nil
+}
+
+// waitReady polls the worker's readiness endpoint through url, forcing the
+// request to machId, until it reports ready or timeout passes.
+// It returns an error wrapping ErrWorkerBoot if the worker never became ready.
+func waitReady(ctx context.Context, url, machId string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	delay := readyPollMin
+	for {
+		err := checkReady(ctx, url, machId)
+		if err == nil {
+			return nil
+		}
+
+		slog.DebugContext(ctx, "pool: waitReady", "mach", machId, "err", err)
+		if sleepWithContext(ctx, delay) != nil {
+			return fmt.Errorf("%w: %s: %v", ErrWorkerBoot, machId, err)
+		}
+		delay = min(2*delay, readyPollMax)
+	}
+}
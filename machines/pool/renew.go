@@ -0,0 +1,79 @@
+package pool
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultRenewRate limits lease renewals to a few machines API calls a second.
+const DefaultRenewRate = rate.Limit(5)
+
+// RenewLeases sets when the leases of free machines are renewed, and how many
+// renewals may be made per second. Leases are renewed when they have less than
+// before left, which defaults to half the lease time. A negative before disables renewal,
+// leaving machines to be recreated when their lease runs out.
+func RenewLeases(before time.Duration, limit rate.Limit) Opt {
+	return func(p *FlyPool) {
+		p.renewBefore = before
+		p.renewLimit = rate.NewLimiter(limit, 1)
+	}
+}
+
+// renewLeases renews the leases of free machines before they run out, until ctx is done.
+// It checks every quarter of renewBefore, with jitter so pools don't renew in step.
+func (p *FlyPool) renewLeases(ctx context.Context) {
+	slog.Debug("pool: renew: starting")
+	interval := max(p.renewBefore/4, time.Second)
+	for {
+		jitter := time.Duration(rand.Int63n(int64(interval)/2+1)) - interval/4
+		if err := sleepWithContext(ctx, interval+jitter); err != nil {
+			break
+		}
+		p.renewDue(ctx)
+	}
+	slog.Debug("pool: renew: exiting")
+	p.wg.Done()
+}
+
+// renewDue renews the leases of free machines that have less than renewBefore left.
+// Each machine is taken off the free list while it is renewed, so it can't be allocated meanwhile.
+func (p *FlyPool) renewDue(ctx context.Context) {
+	due := p.free.find(func(mach *Mach) bool { return !mach.leaseSufficient(p.renewBefore) })
+	for _, mach := range due {
+		if err := p.renewLimit.Wait(ctx); err != nil {
+			return
+		}
+		if !p.free.remove(mach) {
+			// Allocated or discarded since we looked.
+			continue
+		}
+		p.renewLease(ctx, mach)
+	}
+}
+
+// renewLease renews the lease of a free machine that has been taken off the free list,
+// and puts it back. Machines whose lease can't be renewed are kept while it lasts.
+func (p *FlyPool) renewLease(ctx context.Context, mach *Mach) {
+	err := mach.updateLease(ctx, p.now().Add(p.leaseTime))
+	if err != nil {
+		p.stats.renewFailures.Inc()
+		slog.Warn("pool: renew: lease", "name", mach.Name, "mach", mach.Id, "expires", mach.expires(), "err", err)
+		if !mach.leaseSufficient(p.workerTime) {
+			p.discardMach(mach, "lease renewal failed")
+			return
+		}
+	} else {
+		p.stats.renewals.Inc()
+		slog.Debug("pool: renew: lease", "name", mach.Name, "mach", mach.Id, "expires", mach.expires())
+	}
+
+	if p.retire(mach) {
+		p.discardMach(mach, "retired")
+		return
+	}
+	p.free.push(mach)
+}
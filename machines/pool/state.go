@@ -0,0 +1,242 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/superfly/coordBfaas/machines"
+)
+
+// stateWriteDelay is the least time between writes of the state file.
+var stateWriteDelay = time.Second
+
+// StateDir makes the pool keep its machines, their lease nonces and expiries
+// in a file in dir, so that a restarted pool can use them right away,
+// without waiting for the cleaner to find them and fetch their leases.
+// The file is named for the pool and its region, so pools can share dir.
+// It holds lease nonces, so dir should only be readable by coord.
+func StateDir(dir string) Opt {
+	return func(p *FlyPool) { p.stateDir = dir }
+}
+
+// poolStateFile is the contents of the state file.
+type poolStateFile struct {
+	Pool     string      `json:"pool"`
+	Metadata string      `json:"metadata"`
+	Region   string      `json:"region"`
+	Machines []machState `json:"machines"`
+}
+
+type machState struct {
+	Id           string    `json:"id"`
+	Name         string    `json:"name"`
+	InstanceId   string    `json:"instance_id"`
+	LeaseNonce   string    `json:"lease_nonce"`
+	LeaseExpires time.Time `json:"lease_expires"`
+}
+
+// stateEntry returns the machine's entry in the state file, or false if it is not created yet.
+func (mach *Mach) stateEntry() (machState, bool) {
+	mach.mu.Lock()
+	defer mach.mu.Unlock()
+	return machState{
+		Id:           mach.Id,
+		Name:         mach.Name,
+		InstanceId:   mach.InstanceId,
+		LeaseNonce:   mach.leaseNonce,
+		LeaseExpires: mach.leaseExpires,
+	}, mach.Id != ""
+}
+
+func (p *FlyPool) statePath() string {
+	return filepath.Join(p.stateDir, fmt.Sprintf("pool-%s-%s.json", p.name, p.machRegion))
+}
+
+// stateChanged schedules a write of the state file.
+func (p *FlyPool) stateChanged() {
+	select {
+	case p.stateDirty <- struct{}{}:
+	default:
+	}
+}
+
+// writeState writes the state file when it changes, at most every stateWriteDelay,
+// and once more when ctx is done.
+func (p *FlyPool) writeState(ctx context.Context) {
+	slog.Debug("pool: state: starting")
+	for {
+		select {
+		case <-ctx.Done():
+			p.saveState()
+			slog.Debug("pool: state: exiting")
+			p.wg.Done()
+			return
+		case <-p.stateDirty:
+		}
+
+		p.saveState()
+		sleepWithContext(ctx, stateWriteDelay)
+	}
+}
+
+func (p *FlyPool) saveState() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.saveStateLocked()
+}
+
+// saveStateLocked writes the state file, replacing it atomically.
+// Must be called with the lock held.
+func (p *FlyPool) saveStateLocked() {
+	if p.stateDir == "" {
+		return
+	}
+
+	st := poolStateFile{Pool: p.name, Metadata: p.metadata, Region: p.machRegion, Machines: []machState{}}
+	for _, mach := range p.machs {
+		if ms, ok := mach.stateEntry(); ok {
+			st.Machines = append(st.Machines, ms)
+		}
+	}
+	slices.SortFunc(st.Machines, func(a, b machState) int { return strings.Compare(a.Name, b.Name) })
+
+	if err := writeFileAtomic(p.statePath(), &st); err != nil {
+		slog.Error("pool: state: write", "err", err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file and renames it to path.
+func writeFileAtomic(path string, v any) error {
+	bs, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(bs)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", tmp.Name(), err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadState makes the state dir if needed, and adopts the machines in the state file as free machines,
+// if they belong to this pool and have enough lease left.
+// They are reconciled with the machines API on the first cleaning pass.
+func (p *FlyPool) loadState() {
+	if err := os.MkdirAll(p.stateDir, 0700); err != nil {
+		slog.Error("pool: state: make dir", "err", err)
+	}
+	bs, err := os.ReadFile(p.statePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var st poolStateFile
+	if err == nil {
+		err = json.Unmarshal(bs, &st)
+	}
+	if err != nil {
+		slog.Warn("pool: state: read", "path", p.statePath(), "err", err)
+		return
+	}
+	if st.Pool != p.name || st.Metadata != p.metadata || st.Region != p.machRegion {
+		slog.Info("pool: state: not for this pool", "path", p.statePath(), "pool", st.Pool, "metadata", st.Metadata)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cnt := 0
+	for _, ms := range st.Machines {
+		mach := newMachFromFly(p, &machines.MachineResp{
+			Id:         ms.Id,
+			Name:       ms.Name,
+			InstanceId: ms.InstanceId,
+			State:      "stopped",
+		}, ms.LeaseNonce, ms.LeaseExpires)
+		if !mach.leaseSufficient(p.workerTime) || len(p.machs) >= p.capacity {
+			continue
+		}
+
+		mach.fromState = true
+		p.machs[mach.Name] = mach
+		p.free.push(mach)
+		cnt++
+	}
+	slog.Info("pool: state: adopted machines", "count", cnt, "path", p.statePath())
+	p.stats.adopted.Add(int64(cnt))
+	p.stateReconcile = cnt > 0
+}
+
+// reconcileState checks the machines adopted from the state file against
+// the machine listing ms. Free machines that are gone, or whose name now has another ID, are dropped, and
+// free machines that are running are stopped.
+func (p *FlyPool) reconcileState(ctx context.Context, ms []machines.MachineResp) {
+	listed := make(map[string]*machines.MachineResp, len(ms))
+	for i := range ms {
+		listed[ms[i].Name] = &ms[i]
+	}
+
+	p.mu.Lock()
+	var adopted []*Mach
+	for _, mach := range p.machs {
+		if mach.fromState {
+			adopted = append(adopted, mach)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, mach := range adopted {
+		// A machine listed under the name with another ID is not the one in the state file,
+		// whatever its state, so the saved ID and lease nonce are stale.
+		m := listed[mach.Name]
+		gone := m == nil || m.Id != mach.getId() || m.State == "destroyed" || m.State == "destroying"
+		if !gone && m.State == "stopped" {
+			continue
+		}
+		if !p.free.remove(mach) {
+			// Allocated already. If it is gone, it will fail and be discarded.
+			continue
+		}
+
+		if gone {
+			slog.Info("pool: state: machine gone", "name", mach.Name, "mach", mach.Id)
+			p.mu.Lock()
+			delete(p.machs, mach.Name)
+			p.mu.Unlock()
+			p.stateChanged()
+			continue
+		}
+
+		slog.Info("pool: state: stopping machine", "name", mach.Name, "mach", mach.Id, "state", m.State)
+		mach.mu.Lock()
+		mach.InstanceId = m.InstanceId
+		mach.mu.Unlock()
+		if err := mach.stop(ctx); err != nil {
+			p.discardMach(mach, fmt.Sprintf("stop adopted machine failed: %v", err))
+			continue
+		}
+		p.free.push(mach)
+	}
+}
@@ -0,0 +1,147 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"golang.org/x/time/rate"
+
+	"github.com/superfly/coordBfaas/machines"
+	"github.com/superfly/coordBfaas/stats"
+)
+
+func newStatePool(dir string) *FlyPool {
+	p := &FlyPool{
+		name:       "m8001",
+		metadata:   "m8001//image",
+		machRegion: "qmx",
+		capacity:   2,
+		workerTime: time.Minute,
+		now:        time.Now,
+		machs:      make(map[string]*Mach),
+		free:       newMachQueue(),
+		stateDir:   dir,
+		stateDirty: make(chan struct{}, 1),
+	}
+	p.register(stats.NewRegistry())
+	return p
+}
+
+func TestState(t *testing.T) {
+	dir := t.TempDir()
+	p := newStatePool(dir)
+	now := time.Now().Truncate(time.Second)
+	for _, m := range []*Mach{
+		{Id: "1", Name: "worker-m8001-1", leaseNonce: "n1", leaseExpires: now.Add(time.Hour)},
+		{Id: "2", Name: "worker-m8001-2", leaseNonce: "n2", leaseExpires: now.Add(time.Second)},
+		{Id: "", Name: "worker-m8001-3", leaseExpires: now.Add(time.Hour)},
+	} {
+		p.machs[m.Name] = m
+	}
+	p.saveState()
+
+	// Only created machines with enough lease left are adopted.
+	q := newStatePool(dir)
+	q.loadState()
+	assert.Equal(t, 1, q.free.len())
+	mach := q.free.tryPop()
+	assert.Equal(t, "1", mach.Id)
+	assert.Equal(t, "n1", mach.leaseNonce)
+	assert.True(t, mach.leaseExpires.Equal(now.Add(time.Hour)))
+	assert.True(t, mach.fromState)
+	assert.True(t, q.stateReconcile)
+
+	// Other pools ignore the file.
+	q = newStatePool(dir)
+	q.metadata = "m8001//other-image"
+	q.loadState()
+	assert.Equal(t, 0, q.free.len())
+
+	// A missing file is an empty state.
+	assert.NoError(t, os.Remove(p.statePath()))
+	q = newStatePool(dir)
+	q.loadState()
+	assert.Equal(t, 0, q.free.len())
+}
+
+// TestStateConcurrent renews and frees machines while the state is saved and listed.
+// It is meant to be run with -race.
+func TestStateConcurrent(t *testing.T) {
+	p := newStatePool(t.TempDir())
+	_, p.api = newFakeApi(t)
+	p.appName, p.capacity, p.leaseTime, p.renewBefore = "app", 4, time.Hour, time.Hour
+	p.renewLimit = rate.NewLimiter(rate.Inf, 1)
+
+	var machs []*Mach
+	for i := range 4 {
+		m := newMachFromFly(p, &machines.MachineResp{Id: fmt.Sprint(i), Name: fmt.Sprint("worker-m8001-", i), State: "stopped"},
+			"nonce", time.Now().Add(time.Minute))
+		p.machs[m.Name] = m
+		machs = append(machs, m)
+	}
+	// Half the machines are free and get renewed, half are allocated and get freed.
+	p.free.push(machs[0])
+	p.free.push(machs[1])
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				f()
+			}
+		}()
+	}
+	run(func() { p.renewDue(ctx) })
+	run(func() {
+		for _, m := range machs[2:] {
+			m.setState("started")
+			p.freeMach(m)
+		}
+		p.freeWg.Wait()
+		p.free.remove(machs[2])
+		p.free.remove(machs[3])
+	})
+	run(p.saveState)
+	run(func() { p.Machines() })
+	wg.Wait()
+
+	assert.Equal(t, 4, len(p.machs))
+	for _, m := range machs[:2] {
+		assert.True(t, m.leaseSufficient(30*time.Minute))
+	}
+}
+
+func TestStateReconcile(t *testing.T) {
+	dir := t.TempDir()
+	p := newStatePool(dir)
+	exp := time.Now().Add(time.Hour)
+	for _, m := range []*Mach{
+		{Id: "1", Name: "worker-m8001-1", leaseNonce: "n1", leaseExpires: exp},
+		{Id: "2", Name: "worker-m8001-2", leaseNonce: "n2", leaseExpires: exp},
+	} {
+		p.machs[m.Name] = m
+	}
+	p.saveState()
+
+	q := newStatePool(dir)
+	q.loadState()
+	assert.Equal(t, 2, q.free.len())
+
+	// The second name now belongs to another, stopped machine, so the saved entry is stale.
+	q.reconcileState(context.Background(), []machines.MachineResp{
+		{Id: "1", Name: "worker-m8001-1", State: "stopped"},
+		{Id: "9", Name: "worker-m8001-2", State: "stopped"},
+	})
+	assert.Equal(t, 1, q.free.len())
+	assert.Equal(t, "1", q.free.tryPop().getId())
+	_, ok := q.machs["worker-m8001-2"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,77 @@
+package pool
+
+import (
+	"context"
+
+	"github.com/superfly/coordBfaas/stats"
+	"github.com/superfly/coordBfaas/tracing"
+)
+
+// poolStats are the statistics a FlyPool registers.
+type poolStats struct {
+	alloc   *stats.OutcomeTimer
+	create  *stats.OutcomeTimer
+	start   *stats.OutcomeTimer
+	stop    *stats.OutcomeTimer
+	destroy *stats.OutcomeTimer
+	lease   *stats.OutcomeTimer
+	ready   *stats.OutcomeTimer
+
+	wait *stats.Windowed
+
+	discards *stats.Counter
+	adopted  *stats.Counter
+
+	renewals      *stats.Counter
+	renewFailures *stats.Counter
+}
+
+// register registers the pool's statistics in reg, labelled by region and any Labels.
+func (p *FlyPool) register(reg *stats.Registry) {
+	labels := append([]string{"region", p.machRegion}, p.labels...)
+	timer := func(op string) *stats.OutcomeTimer {
+		return reg.OutcomeTimer("bfaas_pool_"+op+"_seconds", "Time spent in pool "+op, labels...)
+	}
+
+	p.stats = poolStats{
+		alloc:   timer("alloc"),
+		create:  timer("create"),
+		start:   timer("start"),
+		stop:    timer("stop"),
+		destroy: timer("destroy"),
+		lease:   timer("lease"),
+		ready:   timer("ready"),
+
+		wait: reg.Timer("bfaas_pool_wait_seconds", "Time allocations spent waiting for a free machine", labels...),
+
+		discards: reg.Counter("bfaas_pool_discards_total", "Machines discarded from the pool", labels...),
+		adopted:  reg.Counter("bfaas_pool_adopted_total", "Machines adopted into the pool", labels...),
+
+		renewals:      reg.Counter("bfaas_pool_lease_renewals_total", "Leases of free machines renewed", labels...),
+		renewFailures: reg.Counter("bfaas_pool_lease_renewal_failures_total", "Failed lease renewals of free machines", labels...),
+	}
+
+	reg.GaugeFunc("bfaas_pool_capacity", "Maximum number of pool machines",
+		func() float64 { return float64(p.Metrics().Capacity) }, labels...)
+	reg.GaugeFunc("bfaas_pool_size", "Number of pool machines",
+		func() float64 { return float64(p.Metrics().Size) }, labels...)
+	reg.GaugeFunc("bfaas_pool_free", "Number of free pool machines",
+		func() float64 { return float64(p.Metrics().Free) }, labels...)
+	reg.GaugeFunc("bfaas_pool_waiting", "Number of allocations waiting for a free machine",
+		func() float64 { return float64(p.waiting.Load()) }, labels...)
+
+	if p.autoscaleCfg != nil {
+		p.autoscaler = newAutoscaler(p, *p.autoscaleCfg, reg, labels)
+	}
+}
+
+// track starts timing and tracing the pool operation op.
+// The returned function ends both, recording err as the outcome.
+func track(ctx context.Context, op string, t *stats.OutcomeTimer) (context.Context, func(err error)) {
+	dt := t.Start()
+	ctx, span := tracing.Start(ctx, "pool", "pool."+op)
+	return ctx, func(err error) {
+		dt.End(err)
+		tracing.End(span, err)
+	}
+}
@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// WriteJSON writes every registered metric as a JSON array.
+// Timers with no values have their statistics omitted, since they are not finite.
+func (p *Registry) WriteJSON(w io.Writer) error {
+	ms := p.Metrics()
+	for i := range ms {
+		if ms[i].Stats != nil && ms[i].Stats.Count == 0 {
+			ms[i].Stats = nil
+		}
+		if ms[i].Recent != nil && ms[i].Recent.Count == 0 {
+			ms[i].Recent = nil
+		}
+	}
+	return json.NewEncoder(w).Encode(ms)
+}
+
+// Log logs every registered metric whose name starts with namePrefix.
+// Timers are logged with their statistics for the last RecentWindow.
+func (p *Registry) Log(logPrefix, namePrefix string) {
+	for _, m := range p.Metrics() {
+		if !strings.HasPrefix(m.Name, namePrefix) {
+			continue
+		}
+
+		var labels []string
+		for _, l := range m.Labels {
+			labels = append(labels, l.Key+"="+l.Value)
+		}
+		if m.Kind == KindTimer {
+			slog.Info(logPrefix, "metric", m.Name, "labels", strings.Join(labels, ","), "window", RecentWindow, "recent", m.Recent)
+		} else {
+			slog.Info(logPrefix, "metric", m.Name, "labels", strings.Join(labels, ","), "value", m.Value)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 // Collector incrementally collects count, average, variance, and standard deviation
 // via the Add() method using Welford's algorithm.
 // Reference: https://en.wikipedia.org/wiki/Algorithms_for_calculating_variance#Welford's_online_algorithm
+// It also keeps a Sketch of the values to estimate quantiles.
 type Collector struct {
 	mu        sync.Mutex
 	Count     float64
@@ -16,13 +17,15 @@ type Collector struct {
 	Max       float64
 	Avg       float64
 	meanDist2 float64
+	hist      *Sketch
 }
 
 // New returns a new statistics collector.
 func New() *Collector {
 	return &Collector{
-		Min: math.Inf(1),
-		Max: math.Inf(-1),
+		Min:  math.Inf(1),
+		Max:  math.Inf(-1),
+		hist: NewSketch(),
 	}
 }
 
@@ -42,6 +45,76 @@ func (p *Collector) Add(x float64) {
 	p.Avg += delta / p.Count
 	delta2 := x - p.Avg
 	p.meanDist2 += delta * delta2
+	p.hist.Add(x)
+}
+
+// Merge accumulates the statistics collected by `o` into p.
+// Reference: https://en.wikipedia.org/wiki/Algorithms_for_calculating_variance#Parallel_algorithm
+func (p *Collector) Merge(o *Collector) {
+	o = o.Snapshot()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if o.Count == 0 {
+		return
+	}
+
+	count := p.Count + o.Count
+	delta := o.Avg - p.Avg
+	p.meanDist2 += o.meanDist2 + delta*delta*p.Count*o.Count/count
+	p.Avg += delta * o.Count / count
+	p.Count = count
+	p.Min = math.Min(p.Min, o.Min)
+	p.Max = math.Max(p.Max, o.Max)
+	p.hist.Merge(o.hist)
+}
+
+// Snapshot returns a copy of the collector as it is now.
+func (p *Collector) Snapshot() *Collector {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := New()
+	n.Count = p.Count
+	n.Min = p.Min
+	n.Max = p.Max
+	n.Avg = p.Avg
+	n.meanDist2 = p.meanDist2
+	n.hist.Merge(p.hist)
+	return n
+}
+
+// Reset discards all collected statistics.
+func (p *Collector) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Count = 0
+	p.Min = math.Inf(1)
+	p.Max = math.Inf(-1)
+	p.Avg = 0
+	p.meanDist2 = 0
+	p.hist = NewSketch()
+}
+
+// Quantile returns an estimate of the q-quantile of the collected values,
+// for q in [0, 1]. It returns NaN if no values have been collected.
+func (p *Collector) Quantile(q float64) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.quantile(q)
+}
+
+// quantile estimates the q-quantile, clamped to the observed range.
+// The caller must hold p.mu.
+func (p *Collector) quantile(q float64) float64 {
+	x := p.hist.Quantile(q)
+	if math.IsNaN(x) {
+		return x
+	}
+	return math.Min(math.Max(x, p.Min), p.Max)
 }
 
 type Stats struct {
@@ -51,6 +124,9 @@ type Stats struct {
 	Avg    float64 `json:"avg"`
 	Var    float64 `json:"var"`
 	StdDev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
 }
 
 // Stats processes the collected statistics and returns it.
@@ -71,11 +147,19 @@ func (p *Collector) Stats() *Stats {
 		Avg:    avg,
 		Var:    v,
 		StdDev: math.Sqrt(v),
+		P50:    p.quantile(0.5),
+		P90:    p.quantile(0.9),
+		P99:    p.quantile(0.99),
 	}
 }
 
+// Adder is anything that values can be added to, such as a Collector or Windowed.
+type Adder interface {
+	Add(x float64)
+}
+
 type Timer struct {
-	c     *Collector
+	c     Adder
 	start time.Time
 }
 
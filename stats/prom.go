@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// WritePrometheus writes every registered metric in Prometheus text format.
+// Timers are written as summaries with count and sum since they were created,
+// quantiles over the last RecentWindow, and separate min and max gauges.
+func (p *Registry) WritePrometheus(w io.Writer) {
+	var last string
+	var extra []Metric
+	flushExtra := func() {
+		writePromMinMax(w, extra)
+		extra = nil
+	}
+
+	for _, m := range p.Metrics() {
+		if m.Name != last {
+			flushExtra()
+			last = m.Name
+			typ := string(m.Kind)
+			if m.Kind == KindTimer {
+				typ = "summary"
+			}
+			fmt.Fprintf(w, "# HELP %s %s\n", m.Name, m.Help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", m.Name, typ)
+		}
+
+		if m.Kind != KindTimer {
+			fmt.Fprintf(w, "%s%s %s\n", m.Name, promLabels(m.Labels), promFloat(m.Value))
+			continue
+		}
+
+		st, recent := m.Stats, m.Recent
+		if recent != nil && recent.Count > 0 {
+			for _, q := range []struct {
+				q string
+				v float64
+			}{{"0.5", recent.P50}, {"0.9", recent.P90}, {"0.99", recent.P99}} {
+				labels := append(slices.Clone(m.Labels), Label{"quantile", q.q})
+				fmt.Fprintf(w, "%s%s %s\n", m.Name, promLabels(labels), promFloat(q.v))
+			}
+		}
+		sum := st.Avg * float64(st.Count)
+		if st.Count == 0 {
+			sum = 0
+		}
+		fmt.Fprintf(w, "%s_count%s %d\n", m.Name, promLabels(m.Labels), st.Count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.Name, promLabels(m.Labels), promFloat(sum))
+		if st.Count > 0 {
+			extra = append(extra, m)
+		}
+	}
+	flushExtra()
+}
+
+// writePromMinMax writes min and max gauges for timers in ms, which all have the same name.
+func writePromMinMax(w io.Writer, ms []Metric) {
+	if len(ms) == 0 {
+		return
+	}
+
+	name, help := ms[0].Name, ms[0].Help
+	fmt.Fprintf(w, "# HELP %s_min Minimum of %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s_min gauge\n", name)
+	for _, m := range ms {
+		fmt.Fprintf(w, "%s_min%s %s\n", name, promLabels(m.Labels), promFloat(m.Stats.Min))
+	}
+	fmt.Fprintf(w, "# HELP %s_max Maximum of %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s_max gauge\n", name)
+	for _, m := range ms {
+		fmt.Fprintf(w, "%s_max%s %s\n", name, promLabels(m.Labels), promFloat(m.Stats.Max))
+	}
+}
+
+// promLabels formats labels the way Prometheus expects.
+func promLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("{")
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l.Value)
+		fmt.Fprintf(&b, "%s=\"%s\"", l.Key, v)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// promFloat formats x the way Prometheus expects.
+func promFloat(x float64) string {
+	switch {
+	case math.IsNaN(x):
+		return "NaN"
+	case math.IsInf(x, 1):
+		return "+Inf"
+	case math.IsInf(x, -1):
+		return "-Inf"
+	default:
+		return fmt.Sprintf("%g", x)
+	}
+}
@@ -0,0 +1,274 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the type of a registered metric.
+type Kind string
+
+const (
+	KindCounter Kind = "counter"
+	KindGauge   Kind = "gauge"
+	KindTimer   Kind = "timer"
+)
+
+// Outcome labels used by OutcomeTimer.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// RecentWindow is the window used for recent statistics, such as quantiles,
+// reported by exporters.
+const RecentWindow = 5 * time.Minute
+
+// Label is a single metric label.
+type Label struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// parseLabels turns key, value pairs into labels sorted by key.
+// It panics if given an odd number of strings.
+func parseLabels(kvs []string) []Label {
+	if len(kvs)%2 != 0 {
+		panic(fmt.Sprintf("stats: odd number of label strings: %q", kvs))
+	}
+
+	labels := make([]Label, 0, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		labels = append(labels, Label{kvs[i], kvs[i+1]})
+	}
+	slices.SortFunc(labels, func(a, b Label) int { return strings.Compare(a.Key, b.Key) })
+	return labels
+}
+
+func labelsKey(labels []Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		fmt.Fprintf(&b, "%s=%q,", l.Key, l.Value)
+	}
+	return b.String()
+}
+
+// Counter is a monotonically increasing count.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Add adds n to the counter.
+func (p *Counter) Add(n int64) {
+	p.v.Add(n)
+}
+
+// Inc adds one to the counter.
+func (p *Counter) Inc() {
+	p.v.Add(1)
+}
+
+// Value returns the current count.
+func (p *Counter) Value() int64 {
+	return p.v.Load()
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits atomic.Uint64
+	f    atomic.Pointer[func() float64]
+}
+
+// Set sets the gauge to x.
+func (p *Gauge) Set(x float64) {
+	p.bits.Store(math.Float64bits(x))
+}
+
+// Value returns the current value of the gauge.
+func (p *Gauge) Value() float64 {
+	if f := p.f.Load(); f != nil {
+		return (*f)()
+	}
+	return math.Float64frombits(p.bits.Load())
+}
+
+// OutcomeTimer times operations and records them separately
+// by whether they succeeded or failed.
+type OutcomeTimer struct {
+	Success *Windowed
+	Failure *Windowed
+}
+
+// OutcomeSpan is a duration measurement started by OutcomeTimer.Start.
+type OutcomeSpan struct {
+	t     *OutcomeTimer
+	start time.Time
+}
+
+// Start starts a duration measurement.
+func (p *OutcomeTimer) Start() OutcomeSpan {
+	return OutcomeSpan{p, time.Now()}
+}
+
+// End finishes a duration measurement, recording it as a failure if err is not nil.
+func (p *OutcomeSpan) End(err error) {
+	dt := time.Now().Sub(p.start).Seconds()
+	if err != nil {
+		p.t.Failure.Add(dt)
+	} else {
+		p.t.Success.Add(dt)
+	}
+}
+
+type series struct {
+	labels  []Label
+	counter *Counter
+	gauge   *Gauge
+	timer   *Windowed
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   Kind
+	series map[string]*series
+}
+
+// Metric is the current value of a single registered series, as passed to exporters.
+type Metric struct {
+	Name   string  `json:"name"`
+	Help   string  `json:"help"`
+	Kind   Kind    `json:"kind"`
+	Labels []Label `json:"labels,omitempty"`
+
+	// Value is set for counters and gauges.
+	Value float64 `json:"value"`
+
+	// Stats and Recent are set for timers. Stats has all values since
+	// the timer was created or reset, and Recent has the last RecentWindow.
+	Stats  *Stats `json:"stats,omitempty"`
+	Recent *Stats `json:"recent,omitempty"`
+}
+
+// Registry is a central collection of labelled counters, gauges and timers.
+// Metrics with the same name must all be the same kind, and are told apart by labels.
+// Getting a metric that is already registered returns the existing metric.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// get returns the series for name and labels, making it with mk if needed.
+// It panics if name is registered as a different kind.
+func (p *Registry) get(name, help string, kind Kind, kvs []string, mk func(*series)) *series {
+	labels := parseLabels(kvs)
+	key := labelsKey(labels)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fam := p.families[name]
+	if fam == nil {
+		fam = &family{name: name, help: help, kind: kind, series: make(map[string]*series)}
+		p.families[name] = fam
+	}
+	if fam.kind != kind {
+		panic(fmt.Sprintf("stats: %s registered as %s not %s", name, fam.kind, kind))
+	}
+
+	s := fam.series[key]
+	if s == nil {
+		s = &series{labels: labels}
+		mk(s)
+		fam.series[key] = s
+	}
+	return s
+}
+
+// Counter returns the counter for name with labels given as key, value pairs.
+func (p *Registry) Counter(name, help string, labels ...string) *Counter {
+	return p.get(name, help, KindCounter, labels, func(s *series) { s.counter = &Counter{} }).counter
+}
+
+// Gauge returns the gauge for name with labels given as key, value pairs.
+func (p *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return p.get(name, help, KindGauge, labels, func(s *series) { s.gauge = &Gauge{} }).gauge
+}
+
+// GaugeFunc registers a gauge for name whose value is computed by f when exported.
+// Registering the same name and labels again replaces f.
+func (p *Registry) GaugeFunc(name, help string, f func() float64, labels ...string) {
+	p.Gauge(name, help, labels...).f.Store(&f)
+}
+
+// Timer returns the timer for name with labels given as key, value pairs.
+// Timers record durations in seconds.
+func (p *Registry) Timer(name, help string, labels ...string) *Windowed {
+	return p.get(name, help, KindTimer, labels, func(s *series) { s.timer = NewWindowed() }).timer
+}
+
+// OutcomeTimer returns a timer for name that adds an `outcome` label
+// of success or failure to the given labels.
+func (p *Registry) OutcomeTimer(name, help string, labels ...string) *OutcomeTimer {
+	return &OutcomeTimer{
+		Success: p.Timer(name, help, append(slices.Clone(labels), "outcome", OutcomeSuccess)...),
+		Failure: p.Timer(name, help, append(slices.Clone(labels), "outcome", OutcomeFailure)...),
+	}
+}
+
+// Metrics returns the current value of every registered series,
+// sorted by name and then by labels.
+func (p *Registry) Metrics() []Metric {
+	p.mu.Lock()
+	var fams []*family
+	for _, fam := range p.families {
+		fams = append(fams, fam)
+	}
+	p.mu.Unlock()
+	slices.SortFunc(fams, func(a, b *family) int { return strings.Compare(a.name, b.name) })
+
+	var ms []Metric
+	for _, fam := range fams {
+		p.mu.Lock()
+		keys := sortedKeys(fam.series)
+		ss := make([]*series, 0, len(keys))
+		for _, k := range keys {
+			ss = append(ss, fam.series[k])
+		}
+		p.mu.Unlock()
+
+		for _, s := range ss {
+			m := Metric{Name: fam.name, Help: fam.help, Kind: fam.kind, Labels: s.labels}
+			switch fam.kind {
+			case KindCounter:
+				m.Value = float64(s.counter.Value())
+			case KindGauge:
+				m.Value = s.gauge.Value()
+			case KindTimer:
+				m.Stats = s.timer.Stats()
+				m.Recent = s.timer.Window(RecentWindow)
+			}
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
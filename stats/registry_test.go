@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+
+	c := reg.Counter("test_total", "test count", "region", "qmx")
+	c.Inc()
+	c.Add(2)
+	assert.Equal(t, c, reg.Counter("test_total", "test count", "region", "qmx"))
+	assert.NotEqual(t, c, reg.Counter("test_total", "test count", "region", "dfw"))
+
+	g := reg.Gauge("test_gauge", "test gauge")
+	g.Set(1.5)
+	reg.GaugeFunc("test_func", "test func", func() float64 { return 7 })
+
+	timer := reg.OutcomeTimer("test_seconds", "test timings", "region", "qmx")
+	timer.Success.Add(1)
+	timer.Success.Add(3)
+	span := timer.Start()
+	span.End(fmt.Errorf("oops"))
+
+	ms := reg.Metrics()
+	assert.Equal(t, 6, len(ms))
+	assert.Equal(t, "test_func", ms[0].Name)
+	assert.Equal(t, 7.0, ms[0].Value)
+	assert.Equal(t, "test_gauge", ms[1].Name)
+	assert.Equal(t, 1.5, ms[1].Value)
+	assert.Equal(t, "test_seconds", ms[2].Name)
+	assert.Equal(t, []Label{{"outcome", "failure"}, {"region", "qmx"}}, ms[2].Labels)
+	assert.Equal(t, 1, ms[2].Stats.Count)
+	assert.Equal(t, []Label{{"outcome", "success"}, {"region", "qmx"}}, ms[3].Labels)
+	assert.Equal(t, 2, ms[3].Stats.Count)
+	assert.Equal(t, 2, ms[3].Recent.Count)
+	assert.Equal(t, []Label{{"region", "dfw"}}, ms[4].Labels)
+	assert.Equal(t, 0.0, ms[4].Value)
+	assert.Equal(t, 3.0, ms[5].Value)
+
+	assert.Panics(t, func() { reg.Gauge("test_total", "wrong kind") })
+	assert.Panics(t, func() { reg.Counter("test_total", "odd labels", "region") })
+}
+
+func TestWritePrometheus(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("test_total", "test count", "region", "qmx").Inc()
+	timer := reg.Timer("test_seconds", "test timings", "op", "a")
+	timer.Add(1)
+	timer.Add(3)
+	reg.Timer("test_seconds", "test timings", "op", "b")
+
+	var buf bytes.Buffer
+	reg.WritePrometheus(&buf)
+	assert.Equal(t, `# HELP test_seconds test timings
+# TYPE test_seconds summary
+test_seconds{op="a",quantile="0.5"} 1
+test_seconds{op="a",quantile="0.9"} 2.9742334234766927
+test_seconds{op="a",quantile="0.99"} 2.9742334234766927
+test_seconds_count{op="a"} 2
+test_seconds_sum{op="a"} 4
+test_seconds_count{op="b"} 0
+test_seconds_sum{op="b"} 0
+# HELP test_seconds_min Minimum of test timings
+# TYPE test_seconds_min gauge
+test_seconds_min{op="a"} 1
+# HELP test_seconds_max Maximum of test timings
+# TYPE test_seconds_max gauge
+test_seconds_max{op="a"} 3
+# HELP test_total test count
+# TYPE test_total counter
+test_total{region="qmx"} 1
+`, buf.String())
+}
+
+func TestWriteJSON(t *testing.T) {
+	reg := NewRegistry()
+	reg.Timer("empty_seconds", "no timings")
+	reg.Timer("test_seconds", "test timings").Add(2)
+
+	var buf bytes.Buffer
+	assert.NoError(t, reg.WriteJSON(&buf))
+
+	var ms []Metric
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &ms))
+	assert.Equal(t, 2, len(ms))
+	assert.Zero(t, ms[0].Stats)
+	assert.Equal(t, 1, ms[1].Stats.Count)
+	assert.Equal(t, 2.0, ms[1].Stats.Avg)
+}
@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"math"
+	"slices"
+)
+
+// sketchAccuracy is the relative accuracy of quantiles returned by a Sketch.
+const sketchAccuracy = 0.01
+
+// sketchMinValue is the smallest value tracked in its own bucket.
+// Smaller values, including zero and negative values, are counted as zero.
+const sketchMinValue = 1e-9
+
+var sketchGamma = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+var sketchLogGamma = math.Log(sketchGamma)
+
+// Sketch is a mergeable histogram with fixed log-scale buckets that
+// estimates quantiles of positive values with a relative error of at most 1%.
+// It follows the DDSketch design.
+// Reference: https://arxiv.org/abs/1908.10693
+//
+// A Sketch is not safe for concurrent use.
+type Sketch struct {
+	bins  map[int]uint64
+	zeros uint64
+	count uint64
+}
+
+// NewSketch returns an empty sketch.
+func NewSketch() *Sketch {
+	return &Sketch{bins: make(map[int]uint64)}
+}
+
+// Add accumulates `x` into the sketch.
+func (p *Sketch) Add(x float64) {
+	p.count += 1
+	if x <= sketchMinValue {
+		p.zeros += 1
+		return
+	}
+	p.bins[sketchIndex(x)] += 1
+}
+
+// Merge accumulates all values in `o` into the sketch.
+func (p *Sketch) Merge(o *Sketch) {
+	p.count += o.count
+	p.zeros += o.zeros
+	for k, n := range o.bins {
+		p.bins[k] += n
+	}
+}
+
+// Count returns the number of values added to the sketch.
+func (p *Sketch) Count() uint64 {
+	return p.count
+}
+
+// Quantile returns an estimate of the q-quantile, for q in [0, 1],
+// using the nearest-rank method.
+// It returns NaN if the sketch is empty.
+func (p *Sketch) Quantile(q float64) float64 {
+	if p.count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	// Nearest rank, so small samples report their tail rather than round it away.
+	rank := uint64(max(math.Ceil(q*float64(p.count))-1, 0))
+	if rank < p.zeros {
+		return 0
+	}
+
+	seen := p.zeros
+	keys := make([]int, 0, len(p.bins))
+	for k := range p.bins {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		seen += p.bins[k]
+		if seen > rank {
+			return sketchValue(k)
+		}
+	}
+	return sketchValue(keys[len(keys)-1])
+}
+
+// sketchIndex returns the bucket holding x.
+func sketchIndex(x float64) int {
+	return int(math.Ceil(math.Log(x) / sketchLogGamma))
+}
+
+// sketchValue returns the representative value for bucket k, which is
+// within sketchAccuracy of every value in the bucket.
+func sketchValue(k int) float64 {
+	return 2 * math.Pow(sketchGamma, float64(k)) / (sketchGamma + 1)
+}
@@ -0,0 +1,81 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func assertRelative(t *testing.T, want, got float64) {
+	t.Helper()
+	assert.True(t, math.Abs(got-want) <= sketchAccuracy*want, "want %v got %v", want, got)
+}
+
+func TestSketchQuantiles(t *testing.T) {
+	s := NewSketch()
+	assert.True(t, math.IsNaN(s.Quantile(0.5)))
+
+	// values 0.001s to 1s.
+	var xs []float64
+	for i := 1; i <= 1000; i++ {
+		x := float64(i) / 1000
+		xs = append(xs, x)
+		s.Add(x)
+	}
+	assert.Equal(t, uint64(1000), s.Count())
+
+	for _, q := range []float64{0, 0.25, 0.5, 0.9, 0.99, 1} {
+		want := xs[max(int(math.Ceil(q*float64(len(xs))))-1, 0)]
+		assertRelative(t, want, s.Quantile(q))
+	}
+}
+
+func TestSketchSmall(t *testing.T) {
+	s := NewSketch()
+	s.Add(1)
+	s.Add(3)
+	assertRelative(t, 1, s.Quantile(0))
+	assertRelative(t, 1, s.Quantile(0.5))
+	assertRelative(t, 3, s.Quantile(0.9))
+	assertRelative(t, 3, s.Quantile(0.99))
+}
+
+func TestSketchZeros(t *testing.T) {
+	s := NewSketch()
+	s.Add(0)
+	s.Add(0)
+	s.Add(5)
+	assert.Equal(t, 0.0, s.Quantile(0.5))
+	assertRelative(t, 5, s.Quantile(1))
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, b, all := NewSketch(), NewSketch(), NewSketch()
+	for i := 1; i <= 100; i++ {
+		a.Add(float64(i))
+		all.Add(float64(i))
+	}
+	for i := 101; i <= 300; i++ {
+		b.Add(float64(i))
+		all.Add(float64(i))
+	}
+
+	a.Merge(b)
+	assert.Equal(t, all.Count(), a.Count())
+	for _, q := range []float64{0.1, 0.5, 0.99} {
+		assert.Equal(t, all.Quantile(q), a.Quantile(q))
+	}
+}
+
+func TestCollectorQuantiles(t *testing.T) {
+	c := New()
+	for i := 1; i <= 100; i++ {
+		c.Add(float64(i))
+	}
+	st := c.Stats()
+	assertRelative(t, 50, st.P50)
+	assertRelative(t, 90, st.P90)
+	assertRelative(t, 99, st.P99)
+	assert.True(t, st.P99 <= st.Max)
+}
@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// windowSlots is the number of sub-collectors each Window is divided into.
+const windowSlots = 12
+
+// DefaultWindows are the window spans used by NewWindowed when none are given.
+var DefaultWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// Window collects statistics for values added during the last span of time.
+// It keeps a ring of sub-collectors that each cover a slice of the span,
+// and merges the live ones when asked for statistics.
+type Window struct {
+	span time.Duration
+	slot time.Duration
+	now  func() time.Time
+
+	mu     sync.Mutex
+	slots  []*Collector
+	epochs []int64
+}
+
+// NewWindow returns a collector for values added in the last span.
+func NewWindow(span time.Duration) *Window {
+	p := &Window{
+		span:   span,
+		slot:   span / windowSlots,
+		now:    time.Now,
+		slots:  make([]*Collector, windowSlots),
+		epochs: make([]int64, windowSlots),
+	}
+	for i := range p.slots {
+		p.slots[i] = New()
+	}
+	return p
+}
+
+// Span returns the length of time covered by the window.
+func (p *Window) Span() time.Duration {
+	return p.span
+}
+
+// epoch returns the number of the slot-sized period containing now.
+func (p *Window) epoch() int64 {
+	return p.now().UnixNano() / int64(p.slot)
+}
+
+// Add accumulates `x` into the current slot, clearing the slot first
+// if it holds values from an earlier pass around the ring.
+func (p *Window) Add(x float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.epoch()
+	i := int(e % windowSlots)
+	if p.epochs[i] != e {
+		p.slots[i].Reset()
+		p.epochs[i] = e
+	}
+	p.slots[i].Add(x)
+}
+
+// Snapshot returns a collector holding the values added in the window.
+func (p *Window) Snapshot() *Collector {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := New()
+	e := p.epoch()
+	for i, slot := range p.slots {
+		if e-p.epochs[i] < windowSlots {
+			c.Merge(slot)
+		}
+	}
+	return c
+}
+
+// Stats returns the statistics for values added in the window.
+func (p *Window) Stats() *Stats {
+	return p.Snapshot().Stats()
+}
+
+// Reset discards all collected statistics.
+func (p *Window) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, slot := range p.slots {
+		slot.Reset()
+		p.epochs[i] = 0
+	}
+}
+
+// Start starts a duration measurement.
+func (p *Window) Start() Timer {
+	return Timer{p, time.Now()}
+}
+
+// Windowed collects statistics since it was created or reset,
+// and over several rolling windows of recent time.
+type Windowed struct {
+	total   *Collector
+	windows []*Window
+}
+
+// NewWindowed returns a collector with rolling windows of the given spans,
+// or DefaultWindows if no spans are given.
+func NewWindowed(spans ...time.Duration) *Windowed {
+	if len(spans) == 0 {
+		spans = DefaultWindows
+	}
+
+	p := &Windowed{total: New()}
+	for _, span := range spans {
+		p.windows = append(p.windows, NewWindow(span))
+	}
+	return p
+}
+
+// Add accumulates `x` into the total and every window.
+func (p *Windowed) Add(x float64) {
+	p.total.Add(x)
+	for _, w := range p.windows {
+		w.Add(x)
+	}
+}
+
+// Start starts a duration measurement.
+func (p *Windowed) Start() Timer {
+	return Timer{p, time.Now()}
+}
+
+// Stats returns the statistics collected since creation or the last reset.
+func (p *Windowed) Stats() *Stats {
+	return p.total.Stats()
+}
+
+// Snapshot returns a copy of the statistics collected since creation or the last reset.
+func (p *Windowed) Snapshot() *Collector {
+	return p.total.Snapshot()
+}
+
+// Window returns the statistics for the window with the given span,
+// or nil if there is no such window.
+func (p *Windowed) Window(span time.Duration) *Stats {
+	for _, w := range p.windows {
+		if w.Span() == span {
+			return w.Stats()
+		}
+	}
+	return nil
+}
+
+// Reset discards all collected statistics.
+func (p *Windowed) Reset() {
+	p.total.Reset()
+	for _, w := range p.windows {
+		w.Reset()
+	}
+}
@@ -0,0 +1,95 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestMerge(t *testing.T) {
+	a, b, all := New(), New(), New()
+	for _, x := range []float64{1, 2, 3} {
+		a.Add(x)
+		all.Add(x)
+	}
+	for _, x := range []float64{10, 20} {
+		b.Add(x)
+		all.Add(x)
+	}
+
+	a.Merge(b)
+	a.Merge(New())
+	got, want := a.Stats(), all.Stats()
+	assert.Equal(t, want.Count, got.Count)
+	assert.Equal(t, want.Min, got.Min)
+	assert.Equal(t, want.Max, got.Max)
+	assertApprox(t, want.Avg, got.Avg)
+	assertApprox(t, want.Var, got.Var)
+}
+
+func TestResetSnapshot(t *testing.T) {
+	c := New()
+	c.Add(5)
+	snap := c.Snapshot()
+	c.Reset()
+	c.Add(1)
+
+	assert.Equal(t, 1, snap.Stats().Count)
+	assert.Equal(t, 5.0, snap.Stats().Avg)
+	assert.Equal(t, 1, c.Stats().Count)
+	assert.Equal(t, 1.0, c.Stats().Max)
+
+	c.Reset()
+	assert.True(t, math.IsNaN(c.Stats().Avg))
+}
+
+func TestWindow(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	w := NewWindow(time.Minute)
+	w.now = func() time.Time { return now }
+
+	w.Add(100)
+	now = now.Add(30 * time.Second)
+	w.Add(1)
+	w.Add(3)
+	st := w.Stats()
+	assert.Equal(t, 3, st.Count)
+	assert.Equal(t, 100.0, st.Max)
+
+	// the first value ages out of the window.
+	now = now.Add(40 * time.Second)
+	st = w.Stats()
+	assert.Equal(t, 2, st.Count)
+	assert.Equal(t, 3.0, st.Max)
+	assertApprox(t, 2, st.Avg)
+
+	// everything ages out.
+	now = now.Add(time.Hour)
+	assert.Equal(t, 0, w.Stats().Count)
+
+	// slots are reused after going around the ring.
+	w.Add(7)
+	assert.Equal(t, 1, w.Stats().Count)
+	assert.Equal(t, 7.0, w.Stats().Max)
+
+	w.Reset()
+	assert.Equal(t, 0, w.Stats().Count)
+}
+
+func TestWindowed(t *testing.T) {
+	w := NewWindowed(time.Minute, time.Hour)
+	w.Add(1)
+	dt := w.Start()
+	dt.End()
+
+	assert.Equal(t, 2, w.Stats().Count)
+	assert.Equal(t, 2, w.Window(time.Minute).Count)
+	assert.Equal(t, 2, w.Window(time.Hour).Count)
+	assert.Zero(t, w.Window(time.Second))
+
+	w.Reset()
+	assert.Equal(t, 0, w.Stats().Count)
+	assert.Equal(t, 0, w.Window(time.Minute).Count)
+}